@@ -2,24 +2,73 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	// ErrUnauthorized API error code
 	ErrUnauthorized = 401
+	// IdempotencyKeyHeader is the header with the key of the change, which could be sent several times.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// RequestIDHeader is the header with id the server gives to the request.
+	RequestIDHeader = "X-Request-ID"
 )
 
-// API struct to manager requests to remote
+// API struct to manager requests to remote. Client is shared by all requests to reuse connections.
+// Requests wait for the Limiter if it's set.
 type API struct {
-	Host string
+	Host    string
+	Client  *http.Client
+	Limiter *RateLimiter
 }
 
-// APIError is a custom error, to handle exceptions outside API calls.
+// NewAPI creates API of the server with the given client, http.DefaultClient is used if the client is nil.
+func NewAPI(host string, client *http.Client) *API {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &API{Host: host, Client: client}
+}
+
+// NewHTTPClient creates the client with the configured timeout and the transport of the configuration,
+// see NewTransport.
+func NewHTTPClient(config *Config) (*http.Client, error) {
+	transport, err := NewTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Transport: transport, Timeout: config.HTTPTimeout}, nil
+}
+
+// APIError is an error response of the server. Message and field errors are taken from JSON body
+// like {"message": "...", "errors": [{"field": "...", "message": "..."}]}, see parseErrorBody.
 type APIError struct {
-	code int
+	code      int
+	message   string
+	fields    []FieldError
+	method    string
+	path      string
+	requestID string
+	// retryAfter is the delay asked by the server before the next request, 0 if it's not given.
+	retryAfter time.Duration
+}
+
+// FieldError is a validation error of the request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 // APIConnectionFailed is an error of no connection.
@@ -27,119 +76,687 @@ type APIConnectionFailed struct {
 	msg string
 }
 
+// newAPIError builds the error of the response with not successful status, reading the error from the body.
+func newAPIError(res *http.Response) *APIError {
+	err := &APIError{code: res.StatusCode, requestID: res.Header.Get(RequestIDHeader), retryAfter: parseRetryAfter(res.Header, time.Now())}
+	if res.Request != nil {
+		err.method = res.Request.Method
+		err.path = res.Request.URL.Path
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<16))
+	err.message, err.fields = parseErrorBody(body)
+
+	return err
+}
+
+// errorBody is the error response of the server. Message is taken from message or error attribute,
+// field errors are either a list of FieldError or an object with messages by field.
+type errorBody struct {
+	Message string          `json:"message"`
+	Error   string          `json:"error"`
+	Errors  json.RawMessage `json:"errors"`
+}
+
+// parseErrorBody returns message and field errors of the error body. Not JSON body is taken as the message
+// if it's short text.
+func parseErrorBody(body []byte) (string, []FieldError) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return "", nil
+	}
+	decoded := errorBody{}
+	err := json.Unmarshal(body, &decoded)
+	if err != nil {
+		if len(body) <= 200 && !bytes.HasPrefix(body, []byte("<")) {
+			return string(body), nil
+		}
+		return "", nil
+	}
+	message := decoded.Message
+	if message == "" {
+		message = decoded.Error
+	}
+	fields := []FieldError{}
+	if json.Unmarshal(decoded.Errors, &fields) != nil {
+		fields = []FieldError{}
+		byField := map[string]interface{}{}
+		if json.Unmarshal(decoded.Errors, &byField) == nil {
+			names := []string{}
+			for name := range byField {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				switch v := byField[name].(type) {
+				case string:
+					fields = append(fields, FieldError{Field: name, Message: v})
+				case []interface{}:
+					for _, m := range v {
+						fields = append(fields, FieldError{Field: name, Message: fmt.Sprint(m)})
+					}
+				}
+			}
+		}
+	}
+	if len(fields) == 0 {
+		fields = nil
+	}
+
+	return message, fields
+}
+
+// Error formats the error as "METHOD path: status: message (field: message) [request id]".
 func (err *APIError) Error() string {
-	return fmt.Sprintf("%d", err.code)
+	var b bytes.Buffer
+	if err.method != "" {
+		b.WriteString(err.method + " " + err.path + ": ")
+	}
+	b.WriteString(strconv.Itoa(err.code))
+	if text := http.StatusText(err.code); text != "" {
+		b.WriteString(" " + text)
+	}
+	if err.message != "" {
+		b.WriteString(": " + err.message)
+	}
+	if len(err.fields) > 0 {
+		fields := []string{}
+		for _, field := range err.fields {
+			fields = append(fields, field.Field+": "+field.Message)
+		}
+		b.WriteString(" (" + strings.Join(fields, "; ") + ")")
+	}
+	if err.requestID != "" {
+		b.WriteString(" [request " + err.requestID + "]")
+	}
+
+	return b.String()
+}
+
+// StatusCode returns HTTP status of the response.
+func (err *APIError) StatusCode() int {
+	return err.code
+}
+
+// Message returns the error message given by the server, empty if there is no message.
+func (err *APIError) Message() string {
+	return err.message
+}
+
+// Fields returns validation errors of the request fields.
+func (err *APIError) Fields() []FieldError {
+	return err.fields
+}
+
+// RequestID returns id of the request given by the server to find it in the server logs.
+func (err *APIError) RequestID() string {
+	return err.requestID
+}
+
+// RetryAfter returns the delay asked by the server by Retry-After header, 0 if the header is not given.
+func (err *APIError) RetryAfter() time.Duration {
+	return err.retryAfter
+}
+
+// IsRateLimited indicates the server rejected the request as one of too many requests.
+func (err *APIError) IsRateLimited() bool {
+	return err.code == http.StatusTooManyRequests
+}
+
+// IsUnauthorized indicates the token is missing, expired or revoked, request with new token could pass.
+func (err *APIError) IsUnauthorized() bool {
+	return err.code == ErrUnauthorized
+}
+
+// IsServerError indicates the server failed or is unavailable.
+func (err *APIError) IsServerError() bool {
+	return err.code >= http.StatusInternalServerError
+}
+
+// IsRetryable indicates the same request could pass later: timeout, rate limit or server error.
+func (err *APIError) IsRetryable() bool {
+	switch err.code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return err.IsServerError()
+}
+
+// IsUnauthorized checks if the error is an API error of the rejected token.
+func IsUnauthorized(err error) bool {
+	var e *APIError
+	return errors.As(err, &e) && e.IsUnauthorized()
+}
+
+// IsRateLimited checks if the error is an API error of too many requests.
+func IsRateLimited(err error) bool {
+	var e *APIError
+	return errors.As(err, &e) && e.IsRateLimited()
+}
+
+// IsUnavailable checks if the request failed because of no connection to the server or the server error.
+func IsUnavailable(err error) bool {
+	var e *APIError
+	var c *APIConnectionFailed
+	return errors.As(err, &c) || (errors.As(err, &e) && e.IsServerError())
+}
+
+// IsRetryable checks if sending the same request again could succeed: there was no connection to the server
+// or it's a retryable API error.
+func IsRetryable(err error) bool {
+	var e *APIError
+	var c *APIConnectionFailed
+	return errors.As(err, &c) || (errors.As(err, &e) && e.IsRetryable())
 }
 
 func (err *APIConnectionFailed) Error() string {
 	return err.msg
 }
 
-// Auth sends an authentication request to remote and return token.
-func (a *API) Auth(username string, password string) (string, error) {
+// connectionFailed wraps the error of the request, which got no response. TLS handshake failures are explained.
+// The request not sent because of the pause asked by the server is not a connection failure.
+func connectionFailed(err error) error {
+	var paused *PausedError
+	if errors.As(err, &paused) {
+		return paused
+	}
+	if msg := describeTLSError(err); msg != "" {
+		return &APIConnectionFailed{msg}
+	}
+	return &APIConnectionFailed{err.Error()}
+}
+
+// do sends the request with the client. The request waits for the limiter and 429 Too Many Requests response
+// pauses the next requests for the time given by Retry-After header.
+func (a *API) do(req *http.Request) (*http.Response, error) {
+	if a.Limiter == nil {
+		return a.Client.Do(req)
+	}
+	err := a.Limiter.Wait(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	return a.Client.Do(req)
+}
+
+// responseError builds the error of the response (see newAPIError). If the server rejected the request as one of
+// too many requests, the limiter pauses requests for the time asked by the server.
+func (a *API) responseError(res *http.Response) *APIError {
+	err := newAPIError(res)
+	if err.IsRateLimited() && a.Limiter != nil {
+		a.Limiter.Pause(err.RetryAfter())
+	}
+
+	return err
+}
+
+// closeBody reads the rest of the response body and closes it, so the connection could be reused.
+func closeBody(res *http.Response) {
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1<<16))
+	res.Body.Close()
+}
+
+// addIdempotencyKey sets the key header if the key is given. The server applies the change once and responds
+// to the next requests with the same key as if the change is already made.
+func addIdempotencyKey(req *http.Request, key string) {
+	if key != "" {
+		req.Header.Add(IdempotencyKeyHeader, key)
+	}
+}
+
+// Auth sends an authentication request to remote and return token. Token lifetime is taken from JWT claims
+// if the token is a JWT, otherwise from X-AUTH-TOKEN-TTL header (seconds).
+func (a *API) Auth(ctx context.Context, username string, password string) (*Token, error) {
 	url := a.Host + "user/login"
-	user := User{Username: username, Password: password}
+	login := LoginRequest{Username: username, Password: password}
 	b := new(bytes.Buffer)
-	err := json.NewEncoder(b).Encode(user)
+	err := json.NewEncoder(b).Encode(login)
 	if err != nil {
-		return "", fmt.Errorf("Unable to encode user %s: %s", username, err.Error())
+		return nil, fmt.Errorf("Unable to encode user %s: %s", username, err.Error())
 	}
-	res, err := http.Post(url, "application/json", b)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, b)
 	if err != nil {
-		if res != nil {
-			res.Body.Close()
-			return "", fmt.Errorf("Auth POST request failed with status %s: %s", res.Status, err.Error())
-		}
-		return "", fmt.Errorf("Auth POST request failed: %s", err.Error())
+		return nil, fmt.Errorf("Creating Auth request failed for user %s: %s", username, err.Error())
+	}
+	req.Header.Add("Content-Type", "application/json")
+	res, err := a.do(req)
+	if err != nil {
+		return nil, connectionFailed(err)
 	}
+	defer closeBody(res)
 	if res.StatusCode >= http.StatusMultipleChoices {
-		return "", fmt.Errorf("Your user %s failed to authenticate with code %d", username, res.StatusCode)
+		return nil, a.responseError(res)
+	}
+	xAuthToken := res.Header.Get("X-AUTH-TOKEN")
+	if xAuthToken == "" {
+		return nil, fmt.Errorf("Token is empty for username: %s", username)
+	}
+	token := &Token{Value: xAuthToken, IssuedAt: time.Now()}
+	if claims, ok := parseJWTClaims(xAuthToken); ok {
+		if !claims.issuedAt().IsZero() {
+			token.IssuedAt = claims.issuedAt()
+		}
+		token.ExpiresAt = claims.expiresAt()
 	}
-	if xAuthToken := res.Header.Get("X-AUTH-TOKEN"); xAuthToken != "" {
-		return xAuthToken, nil
+	if ttl := res.Header.Get("X-AUTH-TOKEN-TTL"); ttl != "" && token.ExpiresAt.IsZero() {
+		seconds, err := strconv.Atoi(ttl)
+		if err == nil && seconds > 0 {
+			token.ExpiresAt = token.IssuedAt.Add(time.Duration(seconds) * time.Second)
+		}
 	}
 
-	return "", fmt.Errorf("Token is empty for username: %s", res.StatusCode)
+	return token, nil
 }
 
-// UserAdd sends a request to remote to create new user.
-func (a *API) UserAdd(token string, newUser *User) error {
+// UserAdd sends a request to remote to create new user. Key is the idempotency key, see addIdempotencyKey.
+func (a *API) UserAdd(ctx context.Context, token string, key string, newUser *UserCreateRequest) error {
 	url := a.Host + "user"
 	b := new(bytes.Buffer)
 	err := json.NewEncoder(b).Encode(newUser)
 	if err != nil {
 		return fmt.Errorf("Unable to encode new user %s: %s", newUser.Username, err.Error())
 	}
-	client := &http.Client{}
-	req, err := http.NewRequest("PUT", url, b)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, b)
 	if err != nil {
 		return fmt.Errorf("Creating UserAdd request failed for user %s: %s", newUser.Username, err.Error())
 	}
-	if req != nil {
-		defer func() {
-			req.Close = true
-		}()
+	req.Header.Add("X-AUTH-TOKEN", token)
+	addIdempotencyKey(req, key)
+	req.Header.Add("Content-Type", "application/json")
+	res, err := a.do(req)
+	if err != nil {
+		return connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return a.responseError(res)
+	}
+
+	return nil
+}
+
+// UserList requests all users, it's allowed to admins only.
+func (a *API) UserList(ctx context.Context, token string) ([]*User, error) {
+	url := a.Host + "user"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Creating UserList request failed: %s", err.Error())
+	}
+	req.Header.Add("X-AUTH-TOKEN", token)
+	res, err := a.do(req)
+	if err != nil {
+		return nil, connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return nil, a.responseError(res)
+	}
+	users := []*User{}
+	err = json.NewDecoder(res.Body).Decode(&users)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode users: %s", err.Error())
+	}
+
+	return users, nil
+}
+
+// UserGet requests a user by id.
+func (a *API) UserGet(ctx context.Context, token string, id string) (*User, error) {
+	url := a.Host + "user/" + id
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Creating UserGet request failed for user %s: %s", id, err.Error())
+	}
+	req.Header.Add("X-AUTH-TOKEN", token)
+	res, err := a.do(req)
+	if err != nil {
+		return nil, connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return nil, a.responseError(res)
+	}
+	user := &User{}
+	err = json.NewDecoder(res.Body).Decode(user)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode user %s: %s", id, err.Error())
+	}
+
+	return user, nil
+}
+
+// UserChangePassword sends a request to set new password of the user.
+func (a *API) UserChangePassword(ctx context.Context, token string, id string, change *PasswordChangeRequest) error {
+	url := a.Host + "user/" + id + "/password"
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(change)
+	if err != nil {
+		return fmt.Errorf("Unable to encode password of user %s: %s", id, err.Error())
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, b)
+	if err != nil {
+		return fmt.Errorf("Creating UserChangePassword request failed for user %s: %s", id, err.Error())
 	}
 	req.Header.Add("X-AUTH-TOKEN", token)
 	req.Header.Add("Content-Type", "application/json")
-	res, err := client.Do(req)
+	res, err := a.do(req)
 	if err != nil {
-		if res != nil {
-			res.Body.Close()
-			return fmt.Errorf("UserAdd failed with status %s: %s", res.Status, err.Error())
-		}
-		return fmt.Errorf("API::UserAdd failed: %s", res.Status, err.Error())
+		return connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return a.responseError(res)
 	}
 
 	return nil
 }
 
-// LinkAdd sends a request to create new link item.
-func (a *API) LinkAdd(token string, link *Link) (*Link, error) {
+// UserUpdate sends a request to change user details and returns the updated user.
+func (a *API) UserUpdate(ctx context.Context, token string, id string, update *UserUpdateRequest) (*User, error) {
+	url := a.Host + "user/" + id
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(update)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encode user %s: %s", id, err.Error())
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, b)
+	if err != nil {
+		return nil, fmt.Errorf("Creating UserUpdate request failed for user %s: %s", id, err.Error())
+	}
+	req.Header.Add("X-AUTH-TOKEN", token)
+	req.Header.Add("Content-Type", "application/json")
+	res, err := a.do(req)
+	if err != nil {
+		return nil, connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return nil, a.responseError(res)
+	}
+	updated := &User{}
+	err = json.NewDecoder(res.Body).Decode(updated)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode user %s: %s", id, err.Error())
+	}
+
+	return updated, nil
+}
+
+// UserDelete sends a request to remove user by id.
+func (a *API) UserDelete(ctx context.Context, token string, id string) error {
+	url := a.Host + "user/" + id
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	if err != nil {
+		return fmt.Errorf("Creating UserDelete request failed for user %s: %s", id, err.Error())
+	}
+	req.Header.Add("X-AUTH-TOKEN", token)
+	res, err := a.do(req)
+	if err != nil {
+		return connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return a.responseError(res)
+	}
+
+	return nil
+}
+
+// TagList requests tags of the current user with number of tagged items.
+func (a *API) TagList(ctx context.Context, token string) ([]*Tag, error) {
+	url := a.Host + "tag"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Creating TagList request failed: %s", err.Error())
+	}
+	req.Header.Add("X-AUTH-TOKEN", token)
+	res, err := a.do(req)
+	if err != nil {
+		return nil, connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return nil, a.responseError(res)
+	}
+	tags := []*Tag{}
+	err = json.NewDecoder(res.Body).Decode(&tags)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode tags: %s", err.Error())
+	}
+
+	return tags, nil
+}
+
+// TagRename sends a request to replace tags in all items of the current user, it's used to rename and merge tags.
+func (a *API) TagRename(ctx context.Context, token string, key string, change *TagChange) error {
+	url := a.Host + "tag/rename"
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(change)
+	if err != nil {
+		return fmt.Errorf("Unable to encode tags change %s: %s", change, err.Error())
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, b)
+	if err != nil {
+		return fmt.Errorf("Creating TagRename request failed for %s: %s", change, err.Error())
+	}
+	req.Header.Add("X-AUTH-TOKEN", token)
+	addIdempotencyKey(req, key)
+	req.Header.Add("Content-Type", "application/json")
+	res, err := a.do(req)
+	if err != nil {
+		return connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return a.responseError(res)
+	}
+
+	return nil
+}
+
+// TagDelete sends a request to remove the tag from all items of the current user.
+func (a *API) TagDelete(ctx context.Context, token string, key string, name string) error {
+	url := a.Host + "tag/" + neturl.PathEscape(name)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	if err != nil {
+		return fmt.Errorf("Creating TagDelete request failed for tag %s: %s", name, err.Error())
+	}
+	req.Header.Add("X-AUTH-TOKEN", token)
+	addIdempotencyKey(req, key)
+	res, err := a.do(req)
+	if err != nil {
+		return connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return a.responseError(res)
+	}
+
+	return nil
+}
+
+// Logout sends a request to revoke the token.
+func (a *API) Logout(ctx context.Context, token string) error {
+	url := a.Host + "user/logout"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return fmt.Errorf("Creating Logout request failed: %s", err.Error())
+	}
+	req.Header.Add("X-AUTH-TOKEN", token)
+	res, err := a.do(req)
+	if err != nil {
+		return connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return a.responseError(res)
+	}
+
+	return nil
+}
+
+// LinkAdd sends a request to create new link item. Key is the idempotency key, see addIdempotencyKey.
+// If the link is already created with the key, the server responds with conflict and the created link.
+func (a *API) LinkAdd(ctx context.Context, token string, key string, link *Link) (*Link, error) {
 	url := a.Host + "item/link"
 	b := new(bytes.Buffer)
 	err := json.NewEncoder(b).Encode(link)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to encode new link %s: %s", link.URL, err.Error())
 	}
-	client := &http.Client{}
-	req, err := http.NewRequest("PUT", url, b)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, b)
 	if err != nil {
 		return nil, fmt.Errorf("Creating itemAdd request failed for item %s: %s", link.URL, err.Error())
 	}
-	if req != nil {
-		defer func() {
-			req.Close = true
-		}()
-	}
 	req.Header.Add("X-AUTH-TOKEN", token)
+	addIdempotencyKey(req, key)
 	req.Header.Add("Content-Type", "application/json")
-	res, err := client.Do(req)
+	res, err := a.do(req)
 	if err != nil {
-		if res != nil {
-			res.Body.Close()
-			return nil, fmt.Errorf("ItemAdd failed with status %s: %s", res.Status, err.Error())
+		return nil, connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode == http.StatusConflict && key != "" {
+		created := &Link{}
+		err = json.NewDecoder(res.Body).Decode(created)
+		if err != nil || created.ID == "" {
+			return nil, a.responseError(res)
 		}
-		return nil, &APIConnectionFailed{err.Error()}
+		return created, nil
+	}
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return nil, a.responseError(res)
+	}
+	created := &Link{}
+	err = json.NewDecoder(res.Body).Decode(created)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode created link %s: %s", link.URL, err.Error())
+	}
+
+	return created, nil
+}
+
+// LinkGet requests a link item by id.
+func (a *API) LinkGet(ctx context.Context, token string, id string) (*Link, error) {
+	url := a.Host + "item/link/" + id
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Creating LinkGet request failed for item %s: %s", id, err.Error())
+	}
+	req.Header.Add("X-AUTH-TOKEN", token)
+	res, err := a.do(req)
+	if err != nil {
+		return nil, connectionFailed(err)
 	}
-	if res.StatusCode == http.StatusUnauthorized {
-		return nil, &APIError{http.StatusUnauthorized}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return nil, a.responseError(res)
+	}
+	link := &Link{}
+	err = json.NewDecoder(res.Body).Decode(link)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode link %s: %s", id, err.Error())
+	}
+
+	return link, nil
+}
+
+// LinkList requests link items of the current user, changed after since.
+// Zero since requests all items. Items removed after since are returned with Deleted flag.
+func (a *API) LinkList(ctx context.Context, token string, since time.Time) ([]*Link, error) {
+	url := a.Host + "item/link"
+	if !since.IsZero() {
+		url += "?updatedSince=" + neturl.QueryEscape(since.UTC().Format(time.RFC3339Nano))
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Creating LinkList request failed: %s", err.Error())
+	}
+	req.Header.Add("X-AUTH-TOKEN", token)
+	res, err := a.do(req)
+	if err != nil {
+		return nil, connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return nil, a.responseError(res)
+	}
+	links := []*Link{}
+	err = json.NewDecoder(res.Body).Decode(&links)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode links: %s", err.Error())
 	}
-	// TODO If response is 200, parse response body into new item object
-	bt := []byte{}
-	res.Body.Read(bt)
 
-	return nil, nil
+	return links, nil
 }
 
-// Ping sends request to special endpoint to check if server is available.
-func (a *API) Ping() bool {
+// LinkUpdate sends a request to replace link item details.
+func (a *API) LinkUpdate(ctx context.Context, token string, key string, link *Link) (*Link, error) {
+	url := a.Host + "item/link/" + link.ID
+	b := new(bytes.Buffer)
+	err := json.NewEncoder(b).Encode(link)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encode link %s: %s", link.ID, err.Error())
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, b)
+	if err != nil {
+		return nil, fmt.Errorf("Creating LinkUpdate request failed for item %s: %s", link.ID, err.Error())
+	}
+	req.Header.Add("X-AUTH-TOKEN", token)
+	addIdempotencyKey(req, key)
+	req.Header.Add("Content-Type", "application/json")
+	res, err := a.do(req)
+	if err != nil {
+		return nil, connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return nil, a.responseError(res)
+	}
+	updated := &Link{}
+	err = json.NewDecoder(res.Body).Decode(updated)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode link %s: %s", link.ID, err.Error())
+	}
+
+	return updated, nil
+}
+
+// LinkDelete sends a request to remove link item by id.
+func (a *API) LinkDelete(ctx context.Context, token string, key string, id string) error {
+	url := a.Host + "item/link/" + id
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	if err != nil {
+		return fmt.Errorf("Creating LinkDelete request failed for item %s: %s", id, err.Error())
+	}
+	req.Header.Add("X-AUTH-TOKEN", token)
+	addIdempotencyKey(req, key)
+	res, err := a.do(req)
+	if err != nil {
+		return connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return a.responseError(res)
+	}
+
+	return nil
+}
+
+// Ping sends request to special endpoint to check if server is available, nil error means it's available.
+func (a *API) Ping(ctx context.Context) error {
 	url := a.Host + "ping"
-	resp, _ := http.Get(url) // if error occurred, ping failed, no need to know why
-	if resp != nil {
-		defer resp.Body.Close()
-		return resp.StatusCode == http.StatusOK
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("Creating Ping request failed: %s", err.Error())
+	}
+	res, err := a.do(req)
+	if err != nil {
+		return connectionFailed(err)
+	}
+	defer closeBody(res)
+	if res.StatusCode != http.StatusOK {
+		return a.responseError(res)
 	}
 
-	return false
+	return nil
 }
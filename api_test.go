@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// trackingTransport counts response bodies, which are not closed yet.
+type trackingTransport struct {
+	transport http.RoundTripper
+	open      int64
+}
+
+func (t *trackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&t.open, 1)
+	res.Body = &trackedBody{ReadCloser: res.Body, transport: t}
+	return res, nil
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	transport *trackingTransport
+	closed    int32
+}
+
+func (b *trackedBody) Close() error {
+	if atomic.CompareAndSwapInt32(&b.closed, 0, 1) {
+		atomic.AddInt64(&b.transport.open, -1)
+	}
+	return b.ReadCloser.Close()
+}
+
+func TestAPIClosesResponseBodies(t *testing.T) {
+	var status int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-AUTH-TOKEN", "token")
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+		if r.Method == "GET" && (r.URL.Path == "/api/user" || r.URL.Path == "/api/tag" || r.URL.Path == "/api/item/link") {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer server.Close()
+	transport := &trackingTransport{transport: http.DefaultTransport}
+	api := NewAPI(server.URL+"/api/", &http.Client{Transport: transport})
+	ctx := context.Background()
+	disabled := true
+	link := &Link{}
+	link.ID = "1"
+	link.URL = "http://google.com"
+	calls := map[string]func() error{
+		"Auth": func() error {
+			_, err := api.Auth(ctx, "user", "pass")
+			return err
+		},
+		"UserAdd": func() error {
+			return api.UserAdd(ctx, "token", "key", &UserCreateRequest{Username: "user", Password: "pass"})
+		},
+		"UserList": func() error {
+			_, err := api.UserList(ctx, "token")
+			return err
+		},
+		"UserGet": func() error {
+			_, err := api.UserGet(ctx, "token", "1")
+			return err
+		},
+		"UserChangePassword": func() error {
+			return api.UserChangePassword(ctx, "token", "1", &PasswordChangeRequest{Password: "pass"})
+		},
+		"UserUpdate": func() error {
+			_, err := api.UserUpdate(ctx, "token", "1", &UserUpdateRequest{Disabled: &disabled})
+			return err
+		},
+		"UserDelete": func() error {
+			return api.UserDelete(ctx, "token", "1")
+		},
+		"TagList": func() error {
+			_, err := api.TagList(ctx, "token")
+			return err
+		},
+		"TagRename": func() error {
+			return api.TagRename(ctx, "token", "key", &TagChange{From: []string{"go"}, To: "golang"})
+		},
+		"TagDelete": func() error {
+			return api.TagDelete(ctx, "token", "key", "go")
+		},
+		"Logout": func() error {
+			return api.Logout(ctx, "token")
+		},
+		"LinkAdd": func() error {
+			_, err := api.LinkAdd(ctx, "token", "key", link)
+			return err
+		},
+		"LinkGet": func() error {
+			_, err := api.LinkGet(ctx, "token", "1")
+			return err
+		},
+		"LinkList": func() error {
+			_, err := api.LinkList(ctx, "token", time.Now())
+			return err
+		},
+		"LinkUpdate": func() error {
+			_, err := api.LinkUpdate(ctx, "token", "key", link)
+			return err
+		},
+		"LinkDelete": func() error {
+			return api.LinkDelete(ctx, "token", "key", "1")
+		},
+		"Ping": func() error {
+			return api.Ping(ctx)
+		},
+	}
+	statuses := []int{http.StatusOK, http.StatusConflict, http.StatusUnauthorized, http.StatusInternalServerError}
+	for _, code := range statuses {
+		atomic.StoreInt32(&status, int32(code))
+		for name, call := range calls {
+			err := call()
+			if code == http.StatusOK && err != nil {
+				t.Errorf("[TestAPIClosesResponseBodies] %s failed: %s", name, err.Error())
+			}
+			if open := atomic.LoadInt64(&transport.open); open != 0 {
+				t.Errorf("[TestAPIClosesResponseBodies] %s left %d response bodies open on status %d", name, open, code)
+				atomic.StoreInt64(&transport.open, 0)
+			}
+		}
+	}
+}
+
+func TestAPIRequestCancelled(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	config := &Config{HTTPTimeout: time.Minute}
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		t.Fatalf("[TestAPIRequestCancelled] Unable to create client: %s", err.Error())
+	}
+	api := NewAPI(server.URL+"/api/", client)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- api.LinkDelete(ctx, "token", "key", "1")
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if _, ok := err.(*APIConnectionFailed); !ok {
+			t.Errorf("[TestAPIRequestCancelled] Expected connection failure, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("[TestAPIRequestCancelled] Request is not cancelled")
+	}
+
+	config.HTTPTimeout = 50 * time.Millisecond
+	client, err = NewHTTPClient(config)
+	if err != nil {
+		t.Fatalf("[TestAPIRequestCancelled] Unable to create client: %s", err.Error())
+	}
+	api = NewAPI(server.URL+"/api/", client)
+	start := time.Now()
+	if api.Ping(context.Background()) == nil {
+		t.Errorf("[TestAPIRequestCancelled] Ping of hung server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("[TestAPIRequestCancelled] Request timed out after %s", elapsed)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	cases := []struct {
+		body     string
+		expected string
+		fields   int
+	}{
+		{
+			`{"message":"Validation failed","errors":[{"field":"username","message":"already taken"},{"field":"password","message":"too short"}]}`,
+			"PUT /api/user: 422 Unprocessable Entity: Validation failed (username: already taken; password: too short) [request req-1]",
+			2,
+		},
+		{
+			`{"error":"Validation failed","errors":{"username":"already taken","password":["too short","too simple"]}}`,
+			"PUT /api/user: 422 Unprocessable Entity: Validation failed (password: too short; password: too simple; username: already taken) [request req-1]",
+			3,
+		},
+		{"username is taken\n", "PUT /api/user: 422 Unprocessable Entity: username is taken [request req-1]", 0},
+		{"<html><body>Error</body></html>", "PUT /api/user: 422 Unprocessable Entity [request req-1]", 0},
+		{"", "PUT /api/user: 422 Unprocessable Entity [request req-1]", 0},
+	}
+	for _, c := range cases {
+		body := c.body
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(RequestIDHeader, "req-1")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(body))
+		}))
+		api := NewAPI(server.URL+"/api/", nil)
+		err := api.UserAdd(context.Background(), "token", "key", &UserCreateRequest{Username: "user", Password: "pass"})
+		server.Close()
+		e, ok := err.(*APIError)
+		if !ok {
+			t.Errorf("[TestAPIError] Expected API error for %q, got %v", c.body, err)
+			continue
+		}
+		if e.Error() != c.expected {
+			t.Errorf("[TestAPIError] Expected=%s;Actual=%s;", c.expected, e.Error())
+		}
+		if len(e.Fields()) != c.fields || e.StatusCode() != http.StatusUnprocessableEntity || e.RequestID() != "req-1" {
+			t.Errorf("[TestAPIError] Unexpected fields %v, status %d or request id %s", e.Fields(), e.StatusCode(), e.RequestID())
+		}
+	}
+
+	helpers := []struct {
+		err          error
+		unauthorized bool
+		retryable    bool
+		unavailable  bool
+	}{
+		{&APIError{code: http.StatusUnauthorized}, true, false, false},
+		{fmt.Errorf("Request failed: %w", &APIError{code: http.StatusUnauthorized}), true, false, false},
+		{&APIError{code: http.StatusUnprocessableEntity}, false, false, false},
+		{&APIError{code: http.StatusRequestTimeout}, false, true, false},
+		{&APIError{code: http.StatusTooManyRequests}, false, true, false},
+		{&APIError{code: http.StatusBadGateway}, false, true, true},
+		{&APIConnectionFailed{"connection refused"}, false, true, true},
+		{fmt.Errorf("Decoding failed"), false, false, false},
+		{nil, false, false, false},
+	}
+	for _, c := range helpers {
+		if IsUnauthorized(c.err) != c.unauthorized || IsRetryable(c.err) != c.retryable || IsUnavailable(c.err) != c.unavailable {
+			t.Errorf("[TestAPIError] %v: unexpected IsUnauthorized=%v, IsRetryable=%v, IsUnavailable=%v", c.err, IsUnauthorized(c.err), IsRetryable(c.err), IsUnavailable(c.err))
+		}
+	}
+}
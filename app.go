@@ -0,0 +1,545 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/satori/go.uuid"
+	s "github.com/viktor-br/jobs-scheduler"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// App keeps the client state shared by the REPL and one-shot commands.
+type App struct {
+	Config      *Config
+	Auth        *Auth
+	Storage     Storage
+	Cache       ItemCache
+	Credentials CredentialStore
+	Scheduler   *s.JobsScheduler
+	Logger      *log.Logger
+	Prompt      *Prompt
+	Out         io.Writer
+	session     *session
+	// waitJobs makes add command block until the job is delivered or saved for later delivery.
+	waitJobs  bool
+	waitersMu sync.Mutex
+	waiters   map[string]chan JobResult
+}
+
+// session keeps state of the profile in use. Jobs accepted by the session are saved to its storage and sent
+// with its authentication only, so switching profiles never sends a job to another server.
+type session struct {
+	config       *Config
+	credentials  CredentialStore
+	auth         *Auth
+	storage      Storage
+	cache        ItemCache
+	scheduler    *s.JobsScheduler
+	jobs         chan Job
+	retries      chan Job
+	noConnection chan bool
+	stop         chan bool
+	stopped      chan bool
+	backoff      *Backoff
+	// ctx is cancelled when the session is stopped to interrupt waiting between attempts.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// tries counts failed attempts to send the job in the session.
+	triesMu sync.Mutex
+	tries   map[string]int
+}
+
+// sessionTries is the number of attempts to send the failed job in the session, the job is sent again
+// on the next start or when the server is available again.
+const sessionTries = 3
+
+// Start opens profile files, runs the jobs scheduler and makes the profile current.
+func (app *App) Start(config *Config) error {
+	sess, err := app.startSession(config)
+	if err != nil {
+		return err
+	}
+	app.use(sess)
+
+	return nil
+}
+
+// startSession opens profile files and runs the jobs scheduler of the profile, the current profile is not changed.
+func (app *App) startSession(config *Config) (*session, error) {
+	credentials, err := NewCredentialStore(config, passphraseReader(app.Prompt))
+	if err != nil {
+		return nil, err
+	}
+	userCredentials, err := setup(config, credentials, app.Prompt)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+	auth := &Auth{}
+	auth.Config = config
+	auth.API = NewAPI(config.APIHost, client)
+	auth.API.Limiter = config.RateLimiter()
+	auth.UserCredentials = userCredentials
+
+	storage, err := NewStorage(config.StoragePath())
+	if err != nil {
+		return nil, fmt.Errorf("Storage opening failed %s", err.Error())
+	}
+	// Done jobs are kept for a while to show their state only
+	_, err = storage.PurgeDone(time.Now().Add(-config.JobsRetention))
+	if err != nil {
+		app.Logger.Printf("done jobs removal failed: %s\n", err.Error())
+	}
+	cache, err := NewItemCache(config.StoragePath())
+	if err != nil {
+		return nil, fmt.Errorf("Items cache opening failed %s", err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sess := &session{
+		config:       config,
+		credentials:  credentials,
+		auth:         auth,
+		storage:      storage,
+		cache:        cache,
+		jobs:         make(chan Job, 10),
+		retries:      make(chan Job),
+		noConnection: make(chan bool),
+		stop:         make(chan bool),
+		stopped:      make(chan bool),
+		backoff:      config.Backoff(),
+		ctx:          ctx,
+		cancel:       cancel,
+		tries:        map[string]int{},
+	}
+	logger := app.Logger
+
+	// Create scheduler with processor, which sends the job to the server of the session.
+	scheduler := s.NewJobsScheduler(func(job s.Job) s.JobResult {
+		switch job.(type) {
+		case Job:
+			attempts, err := storage.Attempt(job.GetID())
+			if err != nil {
+				logger.Printf("job #%s state saving failed: %s\n", job.GetID(), err.Error())
+			}
+			jobResult := sendJob(sess.ctx, auth, job.(Job))
+			jobResult.attempts = attempts
+			return jobResult
+		default:
+			return JobResult{lastError: fmt.Errorf("Unknow job type #%s", job.GetID())}
+		}
+	})
+	sess.scheduler = scheduler
+	// Set up options, failed jobs are sent again by the session after the backoff delay (see retryLater)
+	scheduler.Option(s.MaxTries(1), s.ProcessorsNum(2))
+	scheduler.AddLogger(func(msg string) {
+		logger.Println(msg)
+	})
+	// Add function which process results flow
+	scheduler.AddResultOutput(func(res s.JobResult) {
+		switch res.(type) {
+		case JobResult:
+			jobResult := res.(JobResult)
+			logger.Printf("JobResult received: %v", jobResult)
+			if !jobResult.IsDone() {
+				logger.Printf("job #%s is not done: %s\n", res.GetJobID(), res.(JobResult).lastError.Error())
+				// Send signal, connection failed, so we need to stop send requests and wait reestablishing connection.
+				// Requests cancelled by the session stop are not connection failures.
+				if jobResult.ConnectionFailed() && sess.ctx.Err() == nil {
+					sess.noConnection <- true
+				}
+				// Sending the job again fails the same way, keep it apart until the user retries it.
+				if jobResult.IsCorrupted() {
+					err := buryJob(storage, jobResult)
+					if err != nil {
+						logger.Printf("job #%s moving to dead jobs failed: %s\n", res.GetJobID(), err.Error())
+					}
+					red.Printf("%s failed: %s, see jobs dead\n", jobResult.job, jobResult.lastError.Error())
+				} else {
+					err := storage.SetState(res.GetJobID(), JobFailed, jobResult.lastError.Error())
+					if err != nil {
+						logger.Printf("job #%s state saving failed: %s\n", res.GetJobID(), err.Error())
+					}
+					// Jobs failed because of the connection are sent when the server is available again
+					if !jobResult.ConnectionFailed() && sess.ctx.Err() == nil {
+						err = sess.retryLater(jobResult.job, jobResult.RetryAfter())
+						if err != nil {
+							logger.Printf("job #%s retry scheduling failed: %s\n", res.GetJobID(), err.Error())
+						}
+					}
+				}
+			} else {
+				err := storage.SetState(res.GetJobID(), JobDone, "")
+				if err != nil {
+					logger.Printf("job #%s state saving failed: %s\n", res.GetJobID(), err.Error())
+				}
+				logger.Printf("job #%s successed\n", res.GetJobID())
+				err = app.jobDone(cache, jobResult)
+				if err != nil {
+					logger.Printf("job #%s result caching failed: %s\n", res.GetJobID(), err.Error())
+				}
+			}
+			app.notify(jobResult)
+		default:
+			logger.Println("unknown result type")
+		}
+	})
+	scheduler.Run()
+
+	// Run separate goroutine, which accepts a job and forward it either to scheduler or to local storage.
+	go schedule(app, sess)
+
+	return sess, nil
+}
+
+// use makes the session current.
+func (app *App) use(sess *session) {
+	app.Config = sess.config
+	app.Auth = sess.auth
+	app.Storage = sess.storage
+	app.Cache = sess.cache
+	app.Credentials = sess.credentials
+	app.Scheduler = sess.scheduler
+	app.session = sess
+}
+
+// Stop waits for jobs in progress and stops the current profile session.
+func (app *App) Stop() {
+	if app.session == nil {
+		return
+	}
+	app.session.close()
+	app.session = nil
+}
+
+// close waits for jobs in progress and stops the session.
+func (sess *session) close() {
+	// Jobs waiting for the next attempt are not sent and requests in progress are cancelled,
+	// the jobs are sent on the next start of the session
+	sess.cancel()
+	sess.scheduler.Shutdown()
+	sess.scheduler.Wait()
+	close(sess.stop)
+	<-sess.stopped
+}
+
+// retryLater sends the failed job again after the backoff delay of its failed attempts in the session, without
+// holding a processor meanwhile, or after minDelay if it's longer, e.g. the pause asked by the server. The job is
+// not sent before the delay on reconnection too. After sessionTries
+// attempts the job is left until the next start or reconnection.
+func (sess *session) retryLater(job Job, minDelay time.Duration) error {
+	sess.triesMu.Lock()
+	sess.tries[job.ID]++
+	tries := sess.tries[job.ID]
+	sess.triesMu.Unlock()
+	if tries >= sessionTries {
+		return nil
+	}
+	delay := sess.backoff.Delay(tries - 1)
+	if delay < minDelay {
+		delay = minDelay
+	}
+	err := sess.storage.SetNextAttempt(job.ID, time.Now().Add(delay))
+	if err != nil {
+		return err
+	}
+	go func() {
+		select {
+		case <-sess.backoff.clock().After(delay):
+		case <-sess.ctx.Done():
+			return
+		}
+		select {
+		case sess.retries <- job:
+		case <-sess.stop:
+		}
+	}()
+
+	return nil
+}
+
+// UseProfile starts the session of the given profile and makes it current, then stops the previous session.
+// If the profile could not be started, the current one stays in use.
+func (app *App) UseProfile(name string) error {
+	config, err := app.Config.WithProfile(name)
+	if err != nil {
+		return err
+	}
+	// Sessions of the same profile must not share the storage
+	if app.session != nil && config.Profile == app.Config.Profile {
+		return nil
+	}
+	sess, err := app.startSession(config)
+	if err != nil {
+		return err
+	}
+	previous := app.session
+	app.use(sess)
+	if previous != nil {
+		previous.close()
+	}
+	readAllSavedJobsAndSchedule(sess.scheduler, sess.storage)
+
+	return nil
+}
+
+// prompt returns console prompt, which shows the profile if it's not the default one.
+func (app *App) prompt() string {
+	if app.Config == nil || app.Config.Profile == DefaultProfile {
+		return "cmd"
+	}
+	return app.Config.Profile
+}
+
+// UsageError is returned if command arguments are wrong.
+type UsageError struct {
+	usage string
+}
+
+func (err *UsageError) Error() string {
+	return "Usage: " + err.usage
+}
+
+// splitArgs splits command line by spaces.
+func splitArgs(line string) []string {
+	return strings.FieldsFunc(line, func(c rune) bool {
+		return unicode.IsSpace(c)
+	})
+}
+
+// ErrExit is returned by exit command to stop the console.
+var ErrExit = errors.New("exit")
+
+// errWrongArgs is returned by a command handler, if arguments don't match the command usage.
+var errWrongArgs = errors.New("wrong arguments")
+
+// Run identifies command by the first argument and executes it.
+func (app *App) Run(line string) error {
+	args := splitArgs(line)
+	if len(args) == 0 {
+		return nil
+	}
+	cmd := commands.Find(args[0])
+	if cmd == nil {
+		// If command starts with url, user wants to add link
+		if strings.HasPrefix(args[0], "http://") || strings.HasPrefix(args[0], "https://") {
+			return app.addLink(args)
+		}
+		return &UsageError{fmt.Sprintf("unknown command %s, type help to see the list of commands", args[0])}
+	}
+	err := cmd.Handler(app, args[1:], app.out())
+	if err == errWrongArgs {
+		return &UsageError{cmd.Usage}
+	}
+
+	return err
+}
+
+// out returns writer for commands output.
+func (app *App) out() io.Writer {
+	if app.Out == nil {
+		return os.Stdout
+	}
+	return app.Out
+}
+
+// ctx returns the context of the current session, requests made with it are cancelled when the session stops.
+func (app *App) ctx() context.Context {
+	if app.session == nil {
+		return context.Background()
+	}
+	return app.session.ctx
+}
+
+// addLink parses link and sends it to the jobs queue.
+func (app *App) addLink(args []string) error {
+	item, err := ParseLink(args)
+	if err != nil {
+		return err
+	}
+	link, ok := item.(*Link)
+	if !ok {
+		return fmt.Errorf("Unknown item type: %v", item)
+	}
+	return app.enqueue(Job{ID: uuid.NewV4().String(), Type: JobLinkCreate, Link: link})
+}
+
+// editLink merges changes given in args into the link and sends the update to the jobs queue. The link is
+// taken from the local copy if the server is not available.
+func (app *App) editLink(id string, args []string) error {
+	link, err := getLink(app.ctx(), app.Auth, id)
+	if IsUnavailable(err) {
+		link, err = app.Cache.Get(id)
+		if err == nil && link == nil {
+			err = fmt.Errorf("Server is not available and link %s is not found in the local cache", id)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	err = mergeLink(link, args)
+	if err != nil {
+		return err
+	}
+	link.ID = id
+	err = app.Cache.Put([]*Link{link})
+	if err != nil {
+		return err
+	}
+
+	return app.enqueue(Job{ID: uuid.NewV4().String(), Type: JobLinkUpdate, Link: link})
+}
+
+// deleteLink removes the link from the local copy and sends the removal to the jobs queue.
+func (app *App) deleteLink(id string) error {
+	err := app.Cache.Remove(id)
+	if err != nil {
+		return err
+	}
+
+	return app.enqueue(Job{ID: uuid.NewV4().String(), Type: JobLinkDelete, LinkID: id})
+}
+
+// addUser asks new user details and sends the user to the jobs queue. The password is sealed with
+// the password of the profile credentials, so it's never saved in plaintext.
+func (app *App) addUser() error {
+	newUser, err := readNewUser(app.Prompt)
+	if err != nil {
+		return err
+	}
+	sealed, err := sealSecret(app.Auth.password(), newUser.Password)
+	if err != nil {
+		return fmt.Errorf("Sealing password of the new user failed: %s", err.Error())
+	}
+
+	return app.enqueue(Job{
+		ID:             uuid.NewV4().String(),
+		Type:           JobUserCreate,
+		User:           &UserCreateRequest{Username: newUser.Username},
+		SealedPassword: sealed,
+	})
+}
+
+// changeTags applies the tags change to the local copy and sends it to the jobs queue.
+func (app *App) changeTags(change *TagChange) error {
+	n, err := app.Cache.ChangeTags(change)
+	if err != nil {
+		return err
+	}
+	blue.Fprintf(app.out(), "%d links changed in the local copy\n", n)
+
+	return app.enqueue(Job{ID: uuid.NewV4().String(), Type: JobTagChange, Tags: change})
+}
+
+// enqueue sends the job to the jobs queue of the session, waits for the job if required.
+func (app *App) enqueue(job Job) error {
+	if !app.waitJobs {
+		app.session.jobs <- job
+		return nil
+	}
+
+	wait := app.wait(job.ID)
+	app.session.jobs <- job
+	jobResult := <-wait
+	switch {
+	case jobResult.IsDone():
+		return nil
+	case jobResult.ConnectionFailed():
+		blue.Fprintln(app.out(), "Server is not available, the change is saved and will be sent later")
+		return nil
+	default:
+		return jobResult.lastError
+	}
+}
+
+// retryJob moves the dead job back to the jobs queue.
+func (app *App) retryJob(id string) error {
+	data, err := app.Storage.RetryDead(id)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("Dead job %s is not found", id)
+	}
+	job, err := DecodeJob(data)
+	if err != nil {
+		return err
+	}
+
+	return app.enqueue(job)
+}
+
+// buryJob moves the failed job to the dead jobs with the failure details.
+func buryJob(storage Storage, jobResult JobResult) error {
+	data, err := json.Marshal(jobResult.job)
+	if err != nil {
+		return fmt.Errorf("Encoding job failed: %s", err.Error())
+	}
+	data = withoutPassword(data)
+
+	return storage.MoveToDead(&DeadJob{
+		ID:         jobResult.GetJobID(),
+		Data:       data,
+		LastError:  jobResult.lastError.Error(),
+		StatusCode: jobResult.StatusCode(),
+		Attempts:   jobResult.Attempts(),
+		FailedAt:   time.Now(),
+	})
+}
+
+// jobDone shows the result of the done job and updates the local copy of items of the session.
+func (app *App) jobDone(cache ItemCache, jobResult JobResult) error {
+	job := jobResult.job
+	switch job.Type {
+	case JobLinkCreate:
+		link := jobResult.Link()
+		green.Fprintf(app.out(), "Link created with id %s\n", link.ID)
+		return cache.Put([]*Link{link})
+	case JobLinkUpdate:
+		link := jobResult.Link()
+		green.Fprintf(app.out(), "Link updated %s\n", link)
+		return cache.Put([]*Link{link})
+	case JobLinkDelete:
+		green.Fprintf(app.out(), "Link %s removed\n", job.LinkID)
+		return cache.Remove(job.LinkID)
+	case JobUserCreate:
+		green.Fprintf(app.out(), "User %s created\n", job.User.Username)
+	case JobTagChange:
+		green.Fprintf(app.out(), "Tags changed: %s\n", job.Tags)
+	}
+
+	return nil
+}
+
+// wait registers a channel, which receives the first result of the job.
+func (app *App) wait(jobID string) chan JobResult {
+	app.waitersMu.Lock()
+	defer app.waitersMu.Unlock()
+	if app.waiters == nil {
+		app.waiters = map[string]chan JobResult{}
+	}
+	waiter := make(chan JobResult, 1)
+	app.waiters[jobID] = waiter
+
+	return waiter
+}
+
+// notify passes the job result to the waiting command if any.
+func (app *App) notify(jobResult JobResult) {
+	app.waitersMu.Lock()
+	defer app.waitersMu.Unlock()
+	if waiter, ok := app.waiters[jobResult.GetJobID()]; ok {
+		delete(app.waiters, jobResult.GetJobID())
+		waiter <- jobResult
+	}
+}
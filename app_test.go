@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUseProfile(t *testing.T) {
+	// Server of profile A is not available, server of profile B records requests
+	var requestsA int32
+	serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestsA, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer serverA.Close()
+	var mu sync.Mutex
+	requestsB := []string{}
+	serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/user/login" {
+			w.Header().Set("X-AUTH-TOKEN", "token")
+			return
+		}
+		mu.Lock()
+		requestsB = append(requestsB, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+	}))
+	defer serverB.Close()
+
+	dir := t.TempDir()
+	t.Setenv("LMC_DIR", dir)
+	config, _, err := LoadConfig(dir, nil, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("[TestUseProfile] Unable to load config: %s", err.Error())
+	}
+	for key, value := range map[string]string{"api_host": serverA.URL + "/api/", "credentials_store": CredentialsStoreFile} {
+		if _, err = config.Save(key, value); err != nil {
+			t.Fatalf("[TestUseProfile] Save failed: %s", err.Error())
+		}
+	}
+	if err = config.AddProfile("b", serverB.URL+"/api/"); err != nil {
+		t.Fatalf("[TestUseProfile] AddProfile failed: %s", err.Error())
+	}
+	config, _, err = LoadConfig(dir, nil, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("[TestUseProfile] Unable to load config: %s", err.Error())
+	}
+	for _, name := range []string{DefaultProfile, "b"} {
+		profile, err := config.WithProfile(name)
+		if err != nil {
+			t.Fatalf("[TestUseProfile] Unable to load profile %s: %s", name, err.Error())
+		}
+		os.MkdirAll(profile.ProfileDir(), 0755)
+		store := &FileStore{Filename: profile.CredentialsPath()}
+		if err = store.Save(&UserCredentials{Username: "user", Password: "pass"}); err != nil {
+			t.Fatalf("[TestUseProfile] Unable to save credentials of %s: %s", name, err.Error())
+		}
+	}
+
+	app := &App{Logger: log.New(ioutil.Discard, "", 0), Out: ioutil.Discard, waitJobs: true}
+	err = app.Start(config)
+	if err != nil {
+		t.Fatalf("[TestUseProfile] Unable to start: %s", err.Error())
+	}
+	defer app.Stop()
+	storageA := config.StoragePath()
+
+	// The job is saved to send later while the server of A is not available
+	job := Job{ID: "1", Type: JobLinkDelete, LinkID: "5a1b2c"}
+	err = app.enqueue(job)
+	if err != nil || atomic.LoadInt32(&requestsA) == 0 {
+		t.Fatalf("[TestUseProfile] Job should be tried on A and saved, given %d requests %v", requestsA, err)
+	}
+
+	err = app.UseProfile("b")
+	if err != nil {
+		t.Fatalf("[TestUseProfile] Unable to use profile b: %s", err.Error())
+	}
+	if app.Config.Profile != "b" || app.Config.StoragePath() == storageA {
+		t.Fatalf("[TestUseProfile] Profile b should be in use, given %s %s", app.Config.Profile, app.Config.StoragePath())
+	}
+	// The job of B is done after the saved jobs of B are scheduled
+	err = app.enqueue(Job{ID: "2", Type: JobLinkDelete, LinkID: "6b2c3d"})
+	if err != nil {
+		t.Fatalf("[TestUseProfile] Job should be sent to B: %s", err.Error())
+	}
+	mu.Lock()
+	if len(requestsB) != 1 || requestsB[0] != "DELETE /api/item/link/6b2c3d" {
+		t.Errorf("[TestUseProfile] Only the job of B should be sent to B, given %v", requestsB)
+	}
+	mu.Unlock()
+
+	storage, err := NewStorage(storageA)
+	if err != nil {
+		t.Fatalf("[TestUseProfile] Unable to open storage of A: %s", err.Error())
+	}
+	status, err := storage.Status(job.ID)
+	if err != nil || status == nil || status.State == JobDone {
+		t.Errorf("[TestUseProfile] Job should be kept by A, given %v %v", status, err)
+	}
+}
@@ -1,41 +1,169 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
-// Auth represent authentication info.
+// TokenRefreshBefore is how long before the expiration the token is requested again.
+const TokenRefreshBefore = time.Minute
+
+// Token is an authentication token with its lifetime. Zero ExpiresAt means the expiration is unknown.
+type Token struct {
+	Value     string    `json:"token"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Auth represent authentication info. It's safe for concurrent use: token fields are guarded by the mutex,
+// which is held while new token is requested, so concurrent requests for a new token result in one login.
 type Auth struct {
-	Config          *Config
+	Config *Config
+	// API is the client of the server shared by all requests, a client with default settings is used if nil.
+	API             *API
 	UserCredentials *UserCredentials
 	Token           string
+	IssuedAt        time.Time
+	ExpiresAt       time.Time
+	// now returns current time, time.Now if nil.
+	now func() time.Time
+	mu  sync.Mutex
 }
 
 // GetToken read saved session token, check expiration date and request new token if needed.
-func (a *Auth) GetToken() (string, error) {
-	var err error
-	if a.Token == "" {
-		a.Token, err = a.readToken()
-		if err != nil {
-			return "", fmt.Errorf("Getting token failed: %s", err.Error())
-		}
+func (a *Auth) GetToken(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	err := a.loadToken()
+	if err != nil {
+		return "", fmt.Errorf("Getting token failed: %s", err.Error())
+	}
+	if a.Token == "" || a.expiresSoon() {
+		return a.authenticate(ctx)
 	}
 	return a.Token, nil
 }
 
+// Refresh requests new token to replace the rejected one. If the rejected token is already replaced
+// by another goroutine, the new token is returned without login.
+func (a *Auth) Refresh(ctx context.Context, rejected string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.Token != "" && a.Token != rejected && !a.expiresSoon() {
+		return a.Token, nil
+	}
+	return a.authenticate(ctx)
+}
+
+// CurrentToken returns the saved token with its lifetime without requesting new one, empty value if
+// the user is not authenticated.
+func (a *Auth) CurrentToken() (*Token, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	err := a.loadToken()
+	if err != nil {
+		return nil, err
+	}
+	return &Token{Value: a.Token, IssuedAt: a.IssuedAt, ExpiresAt: a.ExpiresAt}, nil
+}
+
 // Authenticate uses API object to request new token.
-func (a *Auth) Authenticate() (string, error) {
-	var err error
-	api := API{a.Config.APIHost}
-	a.Token, err = api.Auth(a.UserCredentials.Username, a.UserCredentials.Password)
+func (a *Auth) Authenticate(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.authenticate(ctx)
+}
+
+// Logout revokes the token on the server and removes it from memory and the token file. The token is removed
+// even if it could not be revoked, revoked is false then. Servers without logout endpoint and expired tokens
+// are not treated as errors.
+func (a *Auth) Logout(ctx context.Context) (revoked bool, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Token file could be broken, remove it anyway
+	a.loadToken()
+	if a.Token != "" {
+		err = a.api().Logout(ctx, a.Token)
+		if e, ok := err.(*APIError); ok {
+			switch e.StatusCode() {
+			case ErrUnauthorized, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+				err = nil
+			}
+		} else {
+			revoked = err == nil
+		}
+		if err != nil {
+			err = fmt.Errorf("Revoking token failed: %s", err.Error())
+		}
+	}
+	a.setToken(&Token{})
+	writeErr := ioutil.WriteFile(a.Config.AuthTokenPath(), []byte{}, 0600)
+	if writeErr != nil {
+		return revoked, fmt.Errorf("Removing token failed: %s", writeErr.Error())
+	}
+
+	return revoked, err
+}
+
+// SetUserCredentials replaces credentials used to request new token.
+func (a *Auth) SetUserCredentials(credentials *UserCredentials) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.UserCredentials = credentials
+}
+
+// password returns the password of the user credentials, empty if credentials are not set.
+func (a *Auth) password() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.UserCredentials == nil {
+		return ""
+	}
+	return a.UserCredentials.Password
+}
+
+// loadToken reads saved token if there is no token in memory.
+func (a *Auth) loadToken() error {
+	if a.Token != "" {
+		return nil
+	}
+	token, err := a.readToken()
 	if err != nil {
-		return "", fmt.Errorf("Failed to authenticate: %s", err.Error())
+		return err
 	}
-	err = writeToken(a.Config.Dir+string(filepath.Separator)+a.Config.AuthTokenFilename, a.Token)
+	a.setToken(token)
+	return nil
+}
+
+func (a *Auth) authenticate(ctx context.Context) (string, error) {
+	if a.UserCredentials == nil || a.UserCredentials.Username == "" {
+		return "", fmt.Errorf("Failed to authenticate: credentials are not saved, use credentials command")
+	}
+	token, err := a.api().Auth(ctx, a.UserCredentials.Username, a.UserCredentials.Password)
+	if err != nil {
+		return "", fmt.Errorf("Failed to authenticate: %w", err)
+	}
+	if token.IssuedAt.IsZero() {
+		token.IssuedAt = a.currentTime()
+	}
+	a.setToken(token)
+	err = writeToken(a.Config.AuthTokenPath(), token)
 	if err != nil {
 		return "", fmt.Errorf("Writing token failed: %s", err.Error())
 	}
@@ -43,34 +171,135 @@ func (a *Auth) Authenticate() (string, error) {
 	return a.Token, nil
 }
 
-// readToken read token info from special file.
-func (a *Auth) readToken() (string, error) {
-	at, err := ioutil.ReadFile(a.Config.Dir + string(filepath.Separator) + a.Config.AuthTokenFilename)
+// expiresSoon returns true if the token expires in less than TokenRefreshBefore or tenth of its lifetime,
+// which one is shorter.
+func (a *Auth) expiresSoon() bool {
+	if a.ExpiresAt.IsZero() {
+		return false
+	}
+	before := TokenRefreshBefore
+	if !a.IssuedAt.IsZero() {
+		if lifetime := a.ExpiresAt.Sub(a.IssuedAt) / 10; lifetime < before {
+			before = lifetime
+		}
+	}
+	return !a.currentTime().Add(before).Before(a.ExpiresAt)
+}
+
+// api returns the shared API client or a client with default settings.
+func (a *Auth) api() *API {
+	if a.API == nil {
+		return NewAPI(a.Config.APIHost, nil)
+	}
+	return a.API
+}
+
+func (a *Auth) setToken(token *Token) {
+	a.Token = token.Value
+	a.IssuedAt = token.IssuedAt
+	a.ExpiresAt = token.ExpiresAt
+}
+
+func (a *Auth) currentTime() time.Time {
+	if a.now == nil {
+		return time.Now()
+	}
+	return a.now()
+}
+
+// readToken read token info from special file. Token saved by previous versions is a plain string
+// without lifetime, the lifetime is taken from JWT claims then.
+func (a *Auth) readToken() (*Token, error) {
+	at, err := ioutil.ReadFile(a.Config.AuthTokenPath())
 	if err != nil {
-		return "", fmt.Errorf("Reading token from file failed: %s", err.Error())
+		return nil, fmt.Errorf("Reading token from file failed: %s", err.Error())
+	}
+	data := strings.TrimSpace(string(at))
+	if strings.HasPrefix(data, "{") {
+		token := &Token{}
+		err = json.Unmarshal([]byte(data), token)
+		if err != nil {
+			return nil, fmt.Errorf("Decoding token from file failed: %s", err.Error())
+		}
+		return token, nil
+	}
+	token := &Token{Value: data}
+	if claims, ok := parseJWTClaims(data); ok {
+		token.IssuedAt, token.ExpiresAt = claims.issuedAt(), claims.expiresAt()
 	}
-	return strings.TrimSpace(string(at)), nil
+	return token, nil
 }
 
-// writeToken saves auth token to file.
-func writeToken(filename, token string) error {
-	f, err := os.Create(filename)
+// writeToken saves auth token to file. The token is written to a temporary file, which replaces the previous one,
+// so the file is never read partly written.
+func writeToken(filename string, token *Token) error {
+	b, err := json.Marshal(token)
 	if err != nil {
-		return fmt.Errorf("Writing token to file failed: %s", err.Error())
+		return fmt.Errorf("Encoding token failed: %s", err.Error())
 	}
-	defer f.Close()
-	err = f.Truncate(0)
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename))
 	if err != nil {
 		return fmt.Errorf("Writing token to file failed: %s", err.Error())
 	}
-	_, err = f.WriteString(token)
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
 	if err != nil {
+		f.Close()
 		return fmt.Errorf("Writing token to file failed: %s", err.Error())
 	}
 	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Writing token to file failed: %s", err.Error())
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("Writing token to file failed: %s", err.Error())
+	}
+	err = os.Rename(f.Name(), filename)
 	if err != nil {
 		return fmt.Errorf("Writing token to file failed: %s", err.Error())
 	}
 
 	return nil
 }
+
+// jwtClaims are registered JWT claims used by the client.
+type jwtClaims struct {
+	Subject  string `json:"sub"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+}
+
+func (claims *jwtClaims) issuedAt() time.Time {
+	if claims.IssuedAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.IssuedAt, 0)
+}
+
+func (claims *jwtClaims) expiresAt() time.Time {
+	if claims.Expires == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Expires, 0)
+}
+
+// parseJWTClaims decodes claims of the token if it's a JWT. The signature is not verified, the claims are used
+// only to know the token lifetime.
+func parseJWTClaims(token string) (*jwtClaims, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, false
+	}
+	claims := &jwtClaims{}
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return nil, false
+	}
+	return claims, true
+}
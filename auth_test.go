@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testJWT builds unsigned JWT with given claims.
+func testJWT(iat, exp int64) string {
+	payload := fmt.Sprintf(`{"sub":"user","iat":%d,"exp":%d}`, iat, exp)
+	return "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".c2lnbmF0dXJl"
+}
+
+// newTestAuth creates Auth with the profile in temporary folder and the fake server login handler.
+func newTestAuth(t *testing.T, login http.HandlerFunc) (*Auth, *httptest.Server) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/user/login", login)
+	return newTestAuthServer(t, mux)
+}
+
+// newTestAuthServer creates Auth with the profile in temporary folder and the fake server with given handler.
+func newTestAuthServer(t *testing.T, handler http.Handler) (*Auth, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	config := DefaultConfig(t.TempDir())
+	config.APIHost = server.URL + "/api/"
+	_, err := setup(config, &memoryStore{credentials: &UserCredentials{Username: "user", Password: "pass"}}, nil)
+	if err != nil {
+		t.Fatalf("Unable to set up profile: %s", err.Error())
+	}
+	return &Auth{Config: config, UserCredentials: &UserCredentials{Username: "user", Password: "pass"}}, server
+}
+
+func TestAuthenticateTokenLifetime(t *testing.T) {
+	issued := time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC)
+	jwt := testJWT(issued.Unix(), issued.Add(time.Hour).Unix())
+	token := jwt
+	auth, _ := newTestAuth(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-AUTH-TOKEN", token)
+		w.Header().Set("X-AUTH-TOKEN-TTL", "600")
+	})
+
+	_, err := auth.Authenticate(context.Background())
+	if err != nil {
+		t.Fatalf("[TestAuthenticateTokenLifetime] Authenticate failed: %s", err.Error())
+	}
+	if !auth.IssuedAt.Equal(issued) || !auth.ExpiresAt.Equal(issued.Add(time.Hour)) {
+		t.Errorf("[TestAuthenticateTokenLifetime] JWT claims expected, given %v - %v", auth.IssuedAt, auth.ExpiresAt)
+	}
+
+	// Not JWT token uses TTL header
+	token = "opaque-token"
+	before := time.Now()
+	_, err = auth.Authenticate(context.Background())
+	if err != nil {
+		t.Fatalf("[TestAuthenticateTokenLifetime] Authenticate failed: %s", err.Error())
+	}
+	if auth.ExpiresAt.Sub(auth.IssuedAt) != 600*time.Second || auth.IssuedAt.Before(before) {
+		t.Errorf("[TestAuthenticateTokenLifetime] TTL header expected, given %v - %v", auth.IssuedAt, auth.ExpiresAt)
+	}
+
+	// Lifetime is saved with the token
+	saved := &Auth{Config: auth.Config}
+	current, err := saved.CurrentToken()
+	if err != nil || current.Value != "opaque-token" || !current.ExpiresAt.Equal(auth.ExpiresAt) {
+		t.Errorf("[TestAuthenticateTokenLifetime] Unexpected saved token %v %v", current, err)
+	}
+}
+
+func TestGetTokenRefreshesBeforeExpiry(t *testing.T) {
+	var logins int32
+	now := time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC)
+	auth, _ := newTestAuth(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&logins, 1)
+		w.Header().Set("X-AUTH-TOKEN", testJWT(now.Unix(), now.Add(time.Hour).Unix()))
+	})
+	auth.now = func() time.Time { return now }
+
+	// Legacy plain token file with JWT expiring in 30 seconds
+	err := ioutil.WriteFile(auth.Config.AuthTokenPath(), []byte(testJWT(now.Add(-time.Hour).Unix(), now.Add(30*time.Second).Unix())), 0600)
+	if err != nil {
+		t.Fatalf("[TestGetTokenRefreshesBeforeExpiry] Unable to write token: %s", err.Error())
+	}
+	_, err = auth.GetToken(context.Background())
+	if err != nil {
+		t.Fatalf("[TestGetTokenRefreshesBeforeExpiry] GetToken failed: %s", err.Error())
+	}
+	if atomic.LoadInt32(&logins) != 1 || !auth.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("[TestGetTokenRefreshesBeforeExpiry] Token expiring soon should be refreshed, logins %d", logins)
+	}
+
+	now = now.Add(50 * time.Minute)
+	auth.GetToken(context.Background())
+	if atomic.LoadInt32(&logins) != 1 {
+		t.Errorf("[TestGetTokenRefreshesBeforeExpiry] Valid token should not be refreshed, logins %d", logins)
+	}
+	now = now.Add(9*time.Minute + 30*time.Second)
+	auth.GetToken(context.Background())
+	if atomic.LoadInt32(&logins) != 2 {
+		t.Errorf("[TestGetTokenRefreshesBeforeExpiry] Token should be refreshed a minute before expiry, logins %d", logins)
+	}
+}
+
+func TestGetTokenConcurrentLogin(t *testing.T) {
+	var logins int32
+	auth, _ := newTestAuth(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&logins, 1)
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("X-AUTH-TOKEN", fmt.Sprintf("token-%d", n))
+		w.Header().Set("X-AUTH-TOKEN-TTL", "3600")
+	})
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, err := auth.GetToken(context.Background())
+			if err != nil {
+				t.Errorf("[TestGetTokenConcurrentLogin] GetToken failed: %s", err.Error())
+			}
+			tokens[i] = token
+		}(i)
+	}
+	wg.Wait()
+
+	if atomic.LoadInt32(&logins) != 1 {
+		t.Errorf("[TestGetTokenConcurrentLogin] Expected one login, given %d", logins)
+	}
+	for _, token := range tokens {
+		if token != "token-1" {
+			t.Errorf("[TestGetTokenConcurrentLogin] Expected token-1, given %s", token)
+		}
+	}
+}
+
+func TestAuthenticateWrapperConcurrentRefresh(t *testing.T) {
+	var logins int32
+	var current atomic.Value
+	current.Store("")
+	auth, _ := newTestAuth(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&logins, 1)
+		time.Sleep(20 * time.Millisecond)
+		token := fmt.Sprintf("token-%d", n)
+		current.Store(token)
+		w.Header().Set("X-AUTH-TOKEN", token)
+		w.Header().Set("X-AUTH-TOKEN-TTL", "3600")
+	})
+	// Saved token is not expired, but it's revoked on the server
+	err := writeToken(auth.Config.AuthTokenPath(), &Token{Value: "revoked", IssuedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("[TestAuthenticateWrapperConcurrentRefresh] Unable to write token: %s", err.Error())
+	}
+	request := func(token string) error {
+		if token != current.Load().(string) {
+			return &APIError{code: ErrUnauthorized}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := authenticateWrapper(context.Background(), auth, request)
+			if err != nil {
+				t.Errorf("[TestAuthenticateWrapperConcurrentRefresh] Request failed: %s", err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if atomic.LoadInt32(&logins) != 1 {
+		t.Errorf("[TestAuthenticateWrapperConcurrentRefresh] Expected one login, given %d", logins)
+	}
+	saved := &Auth{Config: auth.Config}
+	token, err := saved.CurrentToken()
+	if err != nil || token.Value != "token-1" {
+		t.Errorf("[TestAuthenticateWrapperConcurrentRefresh] Unexpected saved token %v %v", token, err)
+	}
+	// Temporary files are not left in the profile folder
+	files, _ := filepath.Glob(auth.Config.AuthTokenPath() + "?*")
+	if len(files) != 0 {
+		t.Errorf("[TestAuthenticateWrapperConcurrentRefresh] Unexpected files %v", files)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	revoked := ""
+	status := http.StatusOK
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/user/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-AUTH-TOKEN", "token")
+	})
+	mux.HandleFunc("/api/user/logout", func(w http.ResponseWriter, r *http.Request) {
+		revoked = r.Header.Get("X-AUTH-TOKEN")
+		w.WriteHeader(status)
+	})
+	auth, _ := newTestAuthServer(t, mux)
+
+	for _, c := range []struct {
+		status  int
+		revoked bool
+		err     bool
+	}{
+		{http.StatusOK, true, false},
+		{http.StatusNotFound, false, false},
+		{http.StatusUnauthorized, false, false},
+		{http.StatusInternalServerError, false, true},
+	} {
+		status = c.status
+		revoked = ""
+		_, err := auth.Authenticate(context.Background())
+		if err != nil {
+			t.Fatalf("[TestLogout] Authenticate failed: %s", err.Error())
+		}
+		ok, err := auth.Logout(context.Background())
+		if ok != c.revoked || (err != nil) != c.err {
+			t.Errorf("[TestLogout] Status %d: unexpected result %t %v", c.status, ok, err)
+		}
+		if revoked != "token" {
+			t.Errorf("[TestLogout] Status %d: token is not sent to revoke, given %s", c.status, revoked)
+		}
+		// Token is removed anyway
+		if auth.Token != "" {
+			t.Errorf("[TestLogout] Status %d: token is not removed from memory", c.status)
+		}
+		saved, err := (&Auth{Config: auth.Config}).CurrentToken()
+		if err != nil || saved.Value != "" {
+			t.Errorf("[TestLogout] Status %d: token is not removed from file %v %v", c.status, saved, err)
+		}
+	}
+
+	// Nothing to revoke
+	revoked = ""
+	ok, err := auth.Logout(context.Background())
+	if ok || err != nil || revoked != "" {
+		t.Errorf("[TestLogout] Logout without token should do nothing, given %t %v %s", ok, err, revoked)
+	}
+
+	// Forgotten credentials
+	auth.SetUserCredentials(&UserCredentials{})
+	_, err = auth.GetToken(context.Background())
+	if err == nil {
+		t.Errorf("[TestLogout] Authentication without credentials should fail")
+	}
+}
+
+func TestAuthenticateUnavailable(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	auth, server := newTestAuth(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+
+	_, err := auth.GetToken(context.Background())
+	if !IsUnavailable(err) {
+		t.Errorf("[TestAuthenticateUnavailable] Login on status %d should be unavailable, given %v", status, err)
+	}
+	status = http.StatusUnauthorized
+	_, err = auth.GetToken(context.Background())
+	if !IsUnauthorized(err) || IsUnavailable(err) {
+		t.Errorf("[TestAuthenticateUnavailable] Login on status %d should be unauthorized, given %v", status, err)
+	}
+
+	server.Close()
+	_, err = auth.GetToken(context.Background())
+	if !IsUnavailable(err) {
+		t.Errorf("[TestAuthenticateUnavailable] Login without connection should be unavailable, given %v", err)
+	}
+	var c *APIConnectionFailed
+	if !errors.As(err, &c) {
+		t.Errorf("[TestAuthenticateUnavailable] Connection failure expected, given %T", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Clock gives timers, tests replace it to control time.
+type Clock interface {
+	After(time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock of time package.
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Backoff is the exponential backoff policy: the delay starts at Initial and is multiplied by Multiplier
+// after each attempt up to Max. Jitter is the fraction of the delay, which is randomly added or subtracted,
+// so clients don't retry at the same moment.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+	// Clock is the real clock if nil.
+	Clock Clock
+	// Random returns a number in [0, 1), math/rand if nil.
+	Random func() float64
+}
+
+// Delay returns the delay before the next attempt after the given number of failed attempts, starting from 0.
+func (b *Backoff) Delay(attempt int) time.Duration {
+	delay := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt))
+	if delay > float64(b.Max) || math.IsInf(delay, 0) {
+		delay = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		random := rand.Float64
+		if b.Random != nil {
+			random = b.Random
+		}
+		delay += delay * b.Jitter * (2*random() - 1)
+	}
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+
+	return time.Duration(delay)
+}
+
+// Wait waits for the delay of the attempt. It returns the context error if the context is done before.
+func (b *Backoff) Wait(ctx context.Context, attempt int) error {
+	select {
+	case <-b.clock().After(b.Delay(attempt)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Retry calls f until it returns true, waiting between attempts. It returns the context error
+// if the context is done before.
+func (b *Backoff) Retry(ctx context.Context, f func() bool) error {
+	for attempt := 0; ; attempt++ {
+		if f() {
+			return nil
+		}
+		err := b.Wait(ctx, attempt)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (b *Backoff) clock() Clock {
+	if b.Clock == nil {
+		return realClock{}
+	}
+	return b.Clock
+}
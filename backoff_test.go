@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeClock records requested delays, timers fire at once unless the clock is blocked.
+type fakeClock struct {
+	delays  []time.Duration
+	blocked bool
+}
+
+func (clock *fakeClock) After(d time.Duration) <-chan time.Time {
+	clock.delays = append(clock.delays, d)
+	c := make(chan time.Time, 1)
+	if !clock.blocked {
+		c <- time.Time{}
+	}
+	return c
+}
+
+func TestBackoffDelay(t *testing.T) {
+	backoff := &Backoff{Initial: time.Second, Max: 10 * time.Second, Multiplier: 2}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for attempt, delay := range expected {
+		if actual := backoff.Delay(attempt); actual != delay {
+			t.Errorf("[TestBackoffDelay] Attempt %d Expected=%s;Actual=%s;", attempt, delay, actual)
+		}
+	}
+	if actual := backoff.Delay(10000); actual != 10*time.Second {
+		t.Errorf("[TestBackoffDelay] Delay should be capped, given %s", actual)
+	}
+
+	backoff.Jitter = 0.5
+	for _, c := range []struct {
+		random  float64
+		attempt int
+		delay   time.Duration
+	}{
+		{0, 0, 500 * time.Millisecond},
+		{0.5, 1, 2 * time.Second},
+		{0.75, 1, 2500 * time.Millisecond},
+		{0.75, 4, 10 * time.Second},
+		{0, 4, 5 * time.Second},
+	} {
+		random := c.random
+		backoff.Random = func() float64 { return random }
+		if actual := backoff.Delay(c.attempt); actual != c.delay {
+			t.Errorf("[TestBackoffDelay] Attempt %d with random %g Expected=%s;Actual=%s;", c.attempt, c.random, c.delay, actual)
+		}
+	}
+}
+
+func TestBackoffRetry(t *testing.T) {
+	clock := &fakeClock{}
+	backoff := &Backoff{Initial: time.Second, Max: time.Minute, Multiplier: 3, Clock: clock}
+	calls := 0
+	err := backoff.Retry(context.Background(), func() bool {
+		calls++
+		return calls == 4
+	})
+	if err != nil || calls != 4 {
+		t.Errorf("[TestBackoffRetry] Expected 4 calls, given %d %v", calls, err)
+	}
+	expected := []time.Duration{time.Second, 3 * time.Second, 9 * time.Second}
+	if len(clock.delays) != len(expected) {
+		t.Fatalf("[TestBackoffRetry] Expected delays %v, given %v", expected, clock.delays)
+	}
+	for i := range expected {
+		if clock.delays[i] != expected[i] {
+			t.Errorf("[TestBackoffRetry] Expected delays %v, given %v", expected, clock.delays)
+		}
+	}
+
+	// Waiting is interrupted by the context
+	clock = &fakeClock{blocked: true}
+	backoff.Clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = backoff.Retry(ctx, func() bool { return false })
+	if err != context.Canceled {
+		t.Errorf("[TestBackoffRetry] Context error expected, given %v", err)
+	}
+}
+
+func TestWaitForServerAvailable(t *testing.T) {
+	pings := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ping", func(w http.ResponseWriter, r *http.Request) {
+		pings++
+		if pings < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	config := DefaultConfig(t.TempDir())
+	config.APIHost = server.URL + "/api/"
+	auth := &Auth{Config: config}
+
+	clock := &fakeClock{}
+	backoff := &Backoff{Initial: time.Second, Max: time.Minute, Multiplier: 2, Clock: clock}
+	success := make(chan bool, 1)
+	waitForServerAvailable(context.Background(), auth, backoff, success)
+	if len(success) != 1 || pings != 3 || len(clock.delays) != 2 {
+		t.Errorf("[TestWaitForServerAvailable] Success expected after 3 pings, given %d pings, delays %v", pings, clock.delays)
+	}
+
+	// Stopped session doesn't get success
+	<-success
+	pings = 0
+	backoff.Clock = &fakeClock{blocked: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	waitForServerAvailable(ctx, auth, backoff, success)
+	if len(success) != 0 {
+		t.Errorf("[TestWaitForServerAvailable] Success is not expected if the context is cancelled")
+	}
+}
+
+// newRetrySession creates session with the storage and the backoff only, which is enough to retry jobs.
+func newRetrySession(storage Storage, clock Clock) *session {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &session{
+		storage: storage,
+		backoff: &Backoff{Initial: time.Second, Max: time.Minute, Multiplier: 2, Clock: clock},
+		retries: make(chan Job),
+		stop:    make(chan bool),
+		ctx:     ctx,
+		cancel:  cancel,
+		tries:   map[string]int{},
+	}
+}
+
+func TestRetryLater(t *testing.T) {
+	os.Remove(TestDBName)
+	storage, err := NewStorage(TestDBName)
+	if err != nil {
+		t.Fatalf("[TestRetryLater] Unable to create new storage: %s", err.Error())
+	}
+	job := Job{ID: "1", Type: JobLinkDelete, LinkID: "5a1b2c"}
+	storage.Put(job.ID, []byte(`{"id":"1","type":"link.delete","linkId":"5a1b2c"}`))
+
+	// The job waiting for the next attempt is not read with jobs to send
+	sess := newRetrySession(storage, &fakeClock{blocked: true})
+	err = sess.retryLater(job, 0)
+	if err != nil {
+		t.Fatalf("[TestRetryLater] Unable to retry job: %s", err.Error())
+	}
+	saved, err := storage.ReadAll()
+	if err != nil || len(saved) != 0 {
+		t.Errorf("[TestRetryLater] Job should be sent later, given %d %v", len(saved), err)
+	}
+	sess.cancel()
+	// The next session sends failed jobs at once
+	storage, err = NewStorage(TestDBName)
+	if err != nil {
+		t.Fatalf("[TestRetryLater] Unable to open storage: %s", err.Error())
+	}
+	saved, err = storage.ReadAll()
+	if err != nil || len(saved) != 1 {
+		t.Errorf("[TestRetryLater] Job should be sent by the next session, given %d %v", len(saved), err)
+	}
+
+	// Delay depends on failed attempts in the session, the job is left after sessionTries attempts
+	clock := &fakeClock{}
+	sess = newRetrySession(storage, clock)
+	defer sess.cancel()
+	for i := 1; i < sessionTries; i++ {
+		err = sess.retryLater(job, 0)
+		if err != nil {
+			t.Fatalf("[TestRetryLater] Unable to retry job: %s", err.Error())
+		}
+		select {
+		case retried := <-sess.retries:
+			if retried.ID != job.ID {
+				t.Errorf("[TestRetryLater] Expected job %s, given %s", job.ID, retried.ID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("[TestRetryLater] Job is not sent again")
+		}
+	}
+	err = sess.retryLater(job, 0)
+	if err != nil {
+		t.Fatalf("[TestRetryLater] Unable to retry job: %s", err.Error())
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second}
+	if !reflect.DeepEqual(clock.delays, expected) {
+		t.Errorf("[TestRetryLater] Expected delays %v, given %v", expected, clock.delays)
+	}
+
+	// The job is not sent before the end of the pause asked by the server
+	clock = &fakeClock{}
+	sess = newRetrySession(storage, clock)
+	defer sess.cancel()
+	err = sess.retryLater(job, time.Hour)
+	if err != nil {
+		t.Fatalf("[TestRetryLater] Unable to retry job: %s", err.Error())
+	}
+	select {
+	case <-sess.retries:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("[TestRetryLater] Job is not sent again")
+	}
+	if !reflect.DeepEqual(clock.delays, []time.Duration{time.Hour}) {
+		t.Errorf("[TestRetryLater] Expected delay of the pause, given %v", clock.delays)
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ItemCache interface provides access to the local copy of the user's remote items.
+type ItemCache interface {
+	Put([]*Link) error
+	Get(string) (*Link, error)
+	Remove(string) error
+	ReadAll() ([]*Link, error)
+	Search(string, int) ([]*Link, error)
+	Tags() ([]*Tag, error)
+	ChangeTags(*TagChange) (int, error)
+	Cursor() (time.Time, error)
+	SetCursor(time.Time) error
+}
+
+// SqliteItemCache keeps items in the same sqlite db as pending jobs.
+type SqliteItemCache struct {
+	dbPath string
+	// fts is true if sqlite is built with FTS5 (-tags sqlite_fts5), search uses LIKE queries otherwise.
+	fts bool
+}
+
+// Init creates items and sync state tables. The full-text index is created if FTS5 is available.
+func (cache *SqliteItemCache) Init() error {
+	db, err := sql.Open("sqlite3", cache.dbPath)
+	if err != nil {
+		return fmt.Errorf("ItemCache: unable to create db. %s", err.Error())
+	}
+	defer db.Close()
+	query := `
+	CREATE TABLE IF NOT EXISTS items(
+		id TEXT NOT NULL PRIMARY KEY,
+		url TEXT,
+		description TEXT,
+		tags TEXT,
+		updatedAt TEXT
+	);
+	CREATE TABLE IF NOT EXISTS sync_state(
+		key TEXT NOT NULL PRIMARY KEY,
+		value TEXT
+	);
+	`
+	_, err = db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("ItemCache: unable to create items table. %s", err.Error())
+	}
+	// Full-text index keeps the same rowid as items. Tags are indexed as saved JSON,
+	// the tokenizer drops brackets and quotes.
+	_, err = db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS items_fts USING fts5(url, description, tags)")
+	if err != nil {
+		if !strings.Contains(err.Error(), "no such module") {
+			return fmt.Errorf("ItemCache: unable to create search index. %s", err.Error())
+		}
+		// Items changed without the index are indexed again when FTS5 is available
+		_, err = db.Exec("INSERT OR REPLACE INTO sync_state(key, value) VALUES('searchIndex', 'stale')")
+		if err != nil {
+			return fmt.Errorf("ItemCache: unable to save search index state. %s", err.Error())
+		}
+		cache.fts = false
+		return nil
+	}
+	var state string
+	err = db.QueryRow("SELECT value FROM sync_state WHERE key = 'searchIndex'").Scan(&state)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("ItemCache: unable to read search index state. %s", err.Error())
+	}
+	query = `
+	INSERT INTO items_fts(rowid, url, description, tags)
+		SELECT rowid, url, description, tags FROM items WHERE rowid NOT IN (SELECT rowid FROM items_fts);
+	DELETE FROM sync_state WHERE key = 'searchIndex';
+	`
+	if state == "stale" {
+		query = "DELETE FROM items_fts;" + query
+	}
+	_, err = db.Exec(query)
+	if err != nil {
+		return fmt.Errorf("ItemCache: unable to fill search index. %s", err.Error())
+	}
+	cache.fts = true
+	return nil
+}
+
+// Put inserts or replaces items in the cache.
+func (cache *SqliteItemCache) Put(links []*Link) error {
+	db, err := sql.Open("sqlite3", cache.dbPath)
+	if err != nil {
+		return fmt.Errorf("ItemCache: PUT, open db failed. %s", err.Error())
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("ItemCache: PUT, create transaction failed. %s", err.Error())
+	}
+	for _, link := range links {
+		err = putLink(tx, link, cache.fts)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("ItemCache: PUT %s, execute failed. %s", link.ID, err.Error())
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("ItemCache: PUT, the transaction commit failed. %s", err.Error())
+	}
+
+	return nil
+}
+
+// Get returns cached item by id, nil if the item is not cached.
+func (cache *SqliteItemCache) Get(id string) (*Link, error) {
+	db, err := sql.Open("sqlite3", cache.dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("ItemCache: GET %s, open db failed. %s", id, err.Error())
+	}
+	defer db.Close()
+
+	row := db.QueryRow("SELECT id, url, description, tags, updatedAt FROM items WHERE id = ?", id)
+	link, err := scanLink(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ItemCache: GET %s, failed to scan. %s", id, err.Error())
+	}
+
+	return link, nil
+}
+
+// Remove item from the cache by id.
+func (cache *SqliteItemCache) Remove(id string) error {
+	db, err := sql.Open("sqlite3", cache.dbPath)
+	if err != nil {
+		return fmt.Errorf("ItemCache: REMOVE %s, open db failed. %s", id, err.Error())
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("ItemCache: REMOVE %s, create transaction failed. %s", id, err.Error())
+	}
+	if cache.fts {
+		_, err = tx.Exec("DELETE FROM items_fts WHERE rowid IN (SELECT rowid FROM items WHERE id = ?)", id)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("ItemCache: REMOVE %s, delete from index failed. %s", id, err.Error())
+		}
+	}
+	_, err = tx.Exec("DELETE FROM items WHERE id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("ItemCache: REMOVE %s, delete query failed. %s", id, err.Error())
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("ItemCache: REMOVE %s, the transaction commit failed. %s", id, err.Error())
+	}
+
+	return nil
+}
+
+// ReadAll returns all cached items, the most recently updated first.
+func (cache *SqliteItemCache) ReadAll() ([]*Link, error) {
+	var result []*Link
+	db, err := sql.Open("sqlite3", cache.dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("ItemCache: READALL, open db failed. %s", err.Error())
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, url, description, tags, updatedAt FROM items ORDER BY updatedAt DESC")
+	if err != nil {
+		return nil, fmt.Errorf("ItemCache: READALL, query failed. %s", err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ItemCache: READALL, failed to scan. %s", err.Error())
+		}
+		result = append(result, link)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("ItemCache: READALL, reading data failed. %s", err.Error())
+	}
+
+	return result, nil
+}
+
+// Search returns cached items matched by query (see ParseSearchQuery), the most relevant first.
+// Without FTS5 items are matched by LIKE queries (see likeSearchQuery), the most recently updated first.
+func (cache *SqliteItemCache) Search(query string, limit int) ([]*Link, error) {
+	var sqlQuery string
+	var args []interface{}
+	if cache.fts {
+		match, err := ParseSearchQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("ItemCache: SEARCH, %s", err.Error())
+		}
+		tags, tagsArgs, err := tagsSearchQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("ItemCache: SEARCH, %s", err.Error())
+		}
+		where := "items_fts MATCH ?"
+		if tags != "" {
+			where += " AND " + tags
+		}
+		sqlQuery = `
+		SELECT items.id, items.url, items.description, items.tags, items.updatedAt
+		FROM items_fts JOIN items ON items.rowid = items_fts.rowid
+		WHERE ` + where + `
+		ORDER BY items_fts.rank
+		LIMIT ?`
+		args = append(append([]interface{}{match}, tagsArgs...), limit)
+	} else {
+		where, whereArgs, err := likeSearchQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("ItemCache: SEARCH, %s", err.Error())
+		}
+		sqlQuery = "SELECT id, url, description, tags, updatedAt FROM items WHERE " + where + " ORDER BY updatedAt DESC LIMIT ?"
+		args = append(whereArgs, limit)
+	}
+	var result []*Link
+	db, err := sql.Open("sqlite3", cache.dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("ItemCache: SEARCH, open db failed. %s", err.Error())
+	}
+	defer db.Close()
+
+	rows, err := db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ItemCache: SEARCH, query failed. %s", err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ItemCache: SEARCH, failed to scan. %s", err.Error())
+		}
+		result = append(result, link)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("ItemCache: SEARCH, reading data failed. %s", err.Error())
+	}
+
+	return result, nil
+}
+
+// Tags returns tags of cached items with number of tagged items, sorted by name.
+func (cache *SqliteItemCache) Tags() ([]*Tag, error) {
+	var result []*Tag
+	db, err := sql.Open("sqlite3", cache.dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("ItemCache: TAGS, open db failed. %s", err.Error())
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT tag.value, COUNT(*)
+		FROM items, json_each(items.tags) AS tag
+		WHERE tag.type = 'text'
+		GROUP BY tag.value
+		ORDER BY tag.value`)
+	if err != nil {
+		return nil, fmt.Errorf("ItemCache: TAGS, query failed. %s", err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tag := &Tag{}
+		err = rows.Scan(&tag.Name, &tag.Count)
+		if err != nil {
+			return nil, fmt.Errorf("ItemCache: TAGS, failed to scan. %s", err.Error())
+		}
+		result = append(result, tag)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("ItemCache: TAGS, reading data failed. %s", err.Error())
+	}
+
+	return result, nil
+}
+
+// ChangeTags applies the tags change to cached items and returns number of changed items.
+func (cache *SqliteItemCache) ChangeTags(change *TagChange) (int, error) {
+	db, err := sql.Open("sqlite3", cache.dbPath)
+	if err != nil {
+		return 0, fmt.Errorf("ItemCache: CHANGETAGS, open db failed. %s", err.Error())
+	}
+	defer db.Close()
+
+	from, err := json.Marshal(change.From)
+	if err != nil {
+		return 0, fmt.Errorf("ItemCache: CHANGETAGS, unable to encode tags. %s", err.Error())
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("ItemCache: CHANGETAGS, create transaction failed. %s", err.Error())
+	}
+	// Read all matched items before the update, the transaction could not write while rows are open
+	rows, err := tx.Query(`
+		SELECT id, url, description, tags, updatedAt FROM items
+		WHERE EXISTS (SELECT 1 FROM json_each(items.tags) AS tag WHERE tag.value IN (SELECT value FROM json_each(?)))`,
+		string(from))
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("ItemCache: CHANGETAGS, query failed. %s", err.Error())
+	}
+	var links []*Link
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return 0, fmt.Errorf("ItemCache: CHANGETAGS, failed to scan. %s", err.Error())
+		}
+		links = append(links, link)
+	}
+	rows.Close()
+	for _, link := range links {
+		link.Tags, _ = change.Apply(link.Tags)
+		err = putLink(tx, link, cache.fts)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("ItemCache: CHANGETAGS %s, execute failed. %s", link.ID, err.Error())
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("ItemCache: CHANGETAGS, the transaction commit failed. %s", err.Error())
+	}
+
+	return len(links), nil
+}
+
+// Cursor returns the update time of the latest synchronised item, zero time if cache was never synchronised.
+func (cache *SqliteItemCache) Cursor() (time.Time, error) {
+	db, err := sql.Open("sqlite3", cache.dbPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ItemCache: CURSOR, open db failed. %s", err.Error())
+	}
+	defer db.Close()
+
+	var value string
+	err = db.QueryRow("SELECT value FROM sync_state WHERE key = 'updatedSince'").Scan(&value)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ItemCache: CURSOR, query failed. %s", err.Error())
+	}
+	cursor, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ItemCache: CURSOR, unable to parse %s. %s", value, err.Error())
+	}
+
+	return cursor, nil
+}
+
+// SetCursor saves the update time of the latest synchronised item.
+func (cache *SqliteItemCache) SetCursor(cursor time.Time) error {
+	db, err := sql.Open("sqlite3", cache.dbPath)
+	if err != nil {
+		return fmt.Errorf("ItemCache: SETCURSOR, open db failed. %s", err.Error())
+	}
+	defer db.Close()
+
+	_, err = db.Exec("INSERT OR REPLACE INTO sync_state(key, value) VALUES('updatedSince', ?)", cursor.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return fmt.Errorf("ItemCache: SETCURSOR, execute failed. %s", err.Error())
+	}
+
+	return nil
+}
+
+// putLink inserts or updates the link and its full-text index entry if there is the index.
+func putLink(tx *sql.Tx, link *Link, fts bool) error {
+	tags, err := json.Marshal(link.Tags)
+	if err != nil {
+		return err
+	}
+	updatedAt := link.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	var rowID int64
+	err = tx.QueryRow("SELECT rowid FROM items WHERE id = ?", link.ID).Scan(&rowID)
+	switch {
+	case err == sql.ErrNoRows:
+		res, err := tx.Exec("INSERT INTO items(id, url, description, tags, updatedAt) VALUES(?, ?, ?, ?, ?)",
+			link.ID, link.URL, link.Description, string(tags), updatedAt)
+		if err != nil {
+			return err
+		}
+		rowID, err = res.LastInsertId()
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		_, err = tx.Exec("UPDATE items SET url = ?, description = ?, tags = ?, updatedAt = ? WHERE rowid = ?",
+			link.URL, link.Description, string(tags), updatedAt, rowID)
+		if err != nil {
+			return err
+		}
+		if !fts {
+			return nil
+		}
+		_, err = tx.Exec("DELETE FROM items_fts WHERE rowid = ?", rowID)
+		if err != nil {
+			return err
+		}
+	}
+	if !fts {
+		return nil
+	}
+	_, err = tx.Exec("INSERT INTO items_fts(rowid, url, description, tags) VALUES(?, ?, ?, ?)",
+		rowID, link.URL, link.Description, string(tags))
+
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanLink reads items row into new link.
+func scanLink(row rowScanner) (*Link, error) {
+	var tags, updatedAt string
+	link := &Link{}
+	err := row.Scan(&link.ID, &link.URL, &link.Description, &tags, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(tags), &link.Tags)
+	if err != nil {
+		return nil, err
+	}
+	link.UpdatedAt, err = time.Parse(time.RFC3339Nano, updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// NewItemCache creates new items cache entity.
+func NewItemCache(dbPath string) (ItemCache, error) {
+	if dbPath == "" {
+		return nil, fmt.Errorf("ItemCache: please provide non-empty path to the storage")
+	}
+
+	cache := SqliteItemCache{dbPath: dbPath}
+	err := cache.Init()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cache, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+const TestCacheDBName string = "testdata/test_cache.db"
+
+func TestItemCache(t *testing.T) {
+	os.Remove(TestCacheDBName)
+
+	cache, err := NewItemCache(TestCacheDBName)
+	if err != nil {
+		t.Fatalf("[TestItemCache] Unable to create new cache: %s", err.Error())
+	}
+	updatedAt := time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC)
+	link := &Link{}
+	link.ID = "id1"
+	link.URL = "http://google.com"
+	link.Description = "search"
+	link.Tags = []string{"google", "search"}
+	link.UpdatedAt = updatedAt
+	err = cache.Put([]*Link{link})
+	if err != nil {
+		t.Errorf("[TestItemCache] Unable to put link to the cache: %s", err.Error())
+	}
+
+	saved, err := cache.Get("id1")
+	if err != nil {
+		t.Errorf("[TestItemCache] Unable to get link from the cache: %s", err.Error())
+	}
+	if saved == nil || saved.String() != link.String() || !saved.UpdatedAt.Equal(updatedAt) {
+		t.Errorf("[TestItemCache] Expected %v is not equal to given %v", link, saved)
+	}
+
+	links, err := cache.ReadAll()
+	if err != nil {
+		t.Errorf("[TestItemCache] Unable to read all: %s", err.Error())
+	}
+	if len(links) != 1 {
+		t.Errorf("[TestItemCache] results length Expected=1;Actual=%d;", len(links))
+	}
+
+	err = cache.Remove("id1")
+	if err != nil {
+		t.Errorf("[TestItemCache] Unable to remove link from the cache: %s", err.Error())
+	}
+	saved, err = cache.Get("id1")
+	if err != nil {
+		t.Errorf("[TestItemCache] Unable to get link from the cache: %s", err.Error())
+	}
+	if saved != nil {
+		t.Errorf("[TestItemCache] Link was not removed from the cache")
+	}
+}
+
+func TestItemCacheCursor(t *testing.T) {
+	os.Remove(TestCacheDBName)
+
+	cache, err := NewItemCache(TestCacheDBName)
+	if err != nil {
+		t.Fatalf("[TestItemCacheCursor] Unable to create new cache: %s", err.Error())
+	}
+	cursor, err := cache.Cursor()
+	if err != nil {
+		t.Errorf("[TestItemCacheCursor] Unable to read cursor: %s", err.Error())
+	}
+	if !cursor.IsZero() {
+		t.Errorf("[TestItemCacheCursor] Expected zero cursor, given %v", cursor)
+	}
+
+	expected := time.Date(2017, 5, 1, 10, 0, 0, 5, time.UTC)
+	err = cache.SetCursor(expected)
+	if err != nil {
+		t.Errorf("[TestItemCacheCursor] Unable to save cursor: %s", err.Error())
+	}
+	cursor, err = cache.Cursor()
+	if err != nil {
+		t.Errorf("[TestItemCacheCursor] Unable to read cursor: %s", err.Error())
+	}
+	if !cursor.Equal(expected) {
+		t.Errorf("[TestItemCacheCursor] Expected %v is not equal to given %v", expected, cursor)
+	}
+}
@@ -1,73 +1,260 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"os"
-	"strings"
+	"net/http"
 )
 
-func addUser(auth *Auth) (*User, error) {
-	reader := bufio.NewReader(os.Stdin)
+// readNewUser asks details of new user.
+func readNewUser(prompt *Prompt) (*UserCreateRequest, error) {
 	fmt.Println("Please provide new user details")
-	fmt.Print("Enter username: ")
-	username, err := reader.ReadString('\n')
+	username, err := prompt.Ask("Enter username", "")
 	if err != nil {
 		return nil, fmt.Errorf("Could not read new user username: %s", err.Error())
 	}
-	fmt.Print("Enter password: ")
-	password, _ := reader.ReadString('\n')
+	password, err := prompt.AskNewPassword("Enter password")
 	if err != nil {
 		return nil, fmt.Errorf("Could not read new user password: %s", err.Error())
 	}
-	username = strings.TrimSpace(username)
-	password = strings.TrimSpace(password)
-	newUser := &User{Username: username, Password: password}
 
-	api := API{auth.Config.APIHost}
-	err = authenticateWrapper(auth, func(token string) error {
-		err = api.UserAdd(token, newUser)
+	return &UserCreateRequest{Username: username, Password: password}, nil
+}
+
+func createUser(ctx context.Context, auth *Auth, key string, newUser *UserCreateRequest) error {
+	api := auth.api()
+	return authenticateWrapper(ctx, auth, func(token string) error {
+		return api.UserAdd(ctx, token, key, newUser)
+	})
+}
+
+func listUsers(ctx context.Context, auth *Auth) ([]*User, error) {
+	var users []*User
+	api := auth.api()
+	err := authenticateWrapper(ctx, auth, func(token string) error {
+		var err error
+		users, err = api.UserList(ctx, token)
 
 		return err
 	})
 
-	return newUser, err
+	return users, err
 }
 
-func addLink(auth *Auth, link *Link) (*Link, error) {
-	api := API{auth.Config.APIHost}
-	err := authenticateWrapper(auth, func(token string) error {
-		_, err := api.LinkAdd(token, link)
+func getUser(ctx context.Context, auth *Auth, id string) (*User, error) {
+	var user *User
+	api := auth.api()
+	err := authenticateWrapper(ctx, auth, func(token string) error {
+		var err error
+		user, err = api.UserGet(ctx, token, id)
 
 		return err
 	})
 
-	return nil, err
+	return user, err
 }
 
-func checkConnection(auth *Auth) bool {
-	api := API{auth.Config.APIHost}
-	return api.Ping()
+// changeUserPassword reads new password of the user twice and sends it to the server.
+func changeUserPassword(ctx context.Context, auth *Auth, id string, prompt *Prompt) error {
+	password, err := prompt.AskNewPassword("Enter new password")
+	if err != nil {
+		return fmt.Errorf("Could not read new password: %s", err.Error())
+	}
+	api := auth.api()
+	return authenticateWrapper(ctx, auth, func(token string) error {
+		return api.UserChangePassword(ctx, token, id, &PasswordChangeRequest{Password: password})
+	})
 }
 
-// authenticateWrapper add feature of re-authentication in case of HTTP error 401
-func authenticateWrapper(auth *Auth, p func(string) error) error {
-	token, err := auth.GetToken()
-	if err != nil {
+func setUserDisabled(ctx context.Context, auth *Auth, id string, disabled bool) (*User, error) {
+	var user *User
+	api := auth.api()
+	err := authenticateWrapper(ctx, auth, func(token string) error {
+		var err error
+		user, err = api.UserUpdate(ctx, token, id, &UserUpdateRequest{Disabled: &disabled})
+
 		return err
+	})
+
+	return user, err
+}
+
+func deleteUser(ctx context.Context, auth *Auth, id string) error {
+	api := auth.api()
+	return authenticateWrapper(ctx, auth, func(token string) error {
+		return api.UserDelete(ctx, token, id)
+	})
+}
+
+func addLink(ctx context.Context, auth *Auth, key string, link *Link) (*Link, error) {
+	var created *Link
+	api := auth.api()
+	err := authenticateWrapper(ctx, auth, func(token string) error {
+		var err error
+		created, err = api.LinkAdd(ctx, token, key, link)
+
+		return err
+	})
+
+	return created, err
+}
+
+func getLink(ctx context.Context, auth *Auth, id string) (*Link, error) {
+	var link *Link
+	api := auth.api()
+	err := authenticateWrapper(ctx, auth, func(token string) error {
+		var err error
+		link, err = api.LinkGet(ctx, token, id)
+
+		return err
+	})
+
+	return link, err
+}
+
+// syncItems pulls items changed since the last synchronisation into the local cache and returns number of changes.
+func syncItems(ctx context.Context, auth *Auth, cache ItemCache) (int, error) {
+	cursor, err := cache.Cursor()
+	if err != nil {
+		return 0, err
 	}
-	err = p(token)
+	var links []*Link
+	api := auth.api()
+	err = authenticateWrapper(ctx, auth, func(token string) error {
+		var err error
+		links, err = api.LinkList(ctx, token, cursor)
+
+		return err
+	})
 	if err != nil {
-		if e, ok := err.(*APIError); ok {
-			if e.code == ErrUnauthorized {
-				token, err := auth.Authenticate()
-				if err != nil {
-					return err
-				}
-				return p(token)
+		return 0, err
+	}
+	changed := []*Link{}
+	for _, link := range links {
+		if link.Deleted {
+			err = cache.Remove(link.ID)
+			if err != nil {
+				return 0, err
 			}
+		} else {
+			changed = append(changed, link)
 		}
+		if link.UpdatedAt.After(cursor) {
+			cursor = link.UpdatedAt
+		}
+	}
+	err = cache.Put(changed)
+	if err != nil {
+		return 0, err
+	}
+	err = cache.SetCursor(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(links), nil
+}
+
+// mergeLink replaces url, tags and description of the link with the ones given in args.
+// Omitted parts are left as they are.
+func mergeLink(link *Link, args []string) error {
+	parsed, err := ParseLink(args)
+	if err != nil {
 		return err
 	}
+	changes, ok := parsed.(*Link)
+	if !ok {
+		return fmt.Errorf("Only links could be edited")
+	}
+	if changes.URL != "" {
+		link.URL = changes.URL
+	}
+	if len(changes.Tags) > 0 {
+		link.Tags = changes.Tags
+	}
+	if changes.Description != "" {
+		link.Description = changes.Description
+	}
+
 	return nil
 }
+
+func updateLink(ctx context.Context, auth *Auth, key string, link *Link) (*Link, error) {
+	var updated *Link
+	api := auth.api()
+	err := authenticateWrapper(ctx, auth, func(token string) error {
+		var err error
+		updated, err = api.LinkUpdate(ctx, token, key, link)
+
+		return err
+	})
+
+	return updated, err
+}
+
+func deleteLink(ctx context.Context, auth *Auth, key string, id string) error {
+	api := auth.api()
+	return authenticateWrapper(ctx, auth, func(token string) error {
+		return api.LinkDelete(ctx, token, key, id)
+	})
+}
+
+func checkConnection(ctx context.Context, auth *Auth) error {
+	api := auth.api()
+	return api.Ping(ctx)
+}
+
+// authenticateWrapper add feature of re-authentication in case of HTTP error 401
+func authenticateWrapper(ctx context.Context, auth *Auth, p func(string) error) error {
+	token, err := auth.GetToken(ctx)
+	if err != nil {
+		return err
+	}
+	err = p(token)
+	if IsUnauthorized(err) {
+		token, err := auth.Refresh(ctx, token)
+		if err != nil {
+			return err
+		}
+		return p(token)
+	}
+	return err
+}
+
+func listTags(ctx context.Context, auth *Auth) ([]*Tag, error) {
+	var tags []*Tag
+	api := auth.api()
+	err := authenticateWrapper(ctx, auth, func(token string) error {
+		var err error
+		tags, err = api.TagList(ctx, token)
+
+		return err
+	})
+
+	return tags, err
+}
+
+// changeTags sends the tags change to the server, tags are removed one by one. Each removal has own idempotency
+// key made of the key and the tag name. The tag, which is not found, is removed already, e.g. by the previous attempt.
+func changeTags(ctx context.Context, auth *Auth, key string, change *TagChange) error {
+	api := auth.api()
+	return authenticateWrapper(ctx, auth, func(token string) error {
+		if change.To != "" {
+			return api.TagRename(ctx, token, key, change)
+		}
+		for _, name := range change.From {
+			tagKey := ""
+			if key != "" {
+				tagKey = key + "/" + name
+			}
+			err := api.TagDelete(ctx, token, tagKey, name)
+			if e, ok := err.(*APIError); ok && (e.StatusCode() == http.StatusNotFound || e.StatusCode() == http.StatusGone) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -1,25 +1,780 @@
 package main
 
-import "path/filepath"
+import (
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// ConfigFilename is the name of configuration file in the configuration folder.
+	ConfigFilename = "config.toml"
+	// EnvPrefix is the prefix of environment variables, which override configuration file values.
+	EnvPrefix = "LMC_"
+	// DefaultProfile is the profile, which options are set at the top level of the configuration file.
+	DefaultProfile = "default"
+)
+
+// Sources of configuration values in order of precedence, the last one wins.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
 
 // Config represents configuration parameters.
 type Config struct {
-	Dir                 string
+	Dir string
+	// Profile is the name of the server profile, each profile has own API host, credentials, token and jobs.
+	Profile             string
 	AuthTokenFilename   string
 	CredentialsFilename string
 	APIHost             string
 	LogFilename         string
 	StorageName         string
+	CredentialsStore    string
+	// Backoff options set delays between attempts to reach the server and to send a failed job.
+	BackoffInitial    time.Duration
+	BackoffMax        time.Duration
+	BackoffMultiplier float64
+	BackoffJitter     float64
+	// JobsRetention is the time done jobs are kept to show their state.
+	JobsRetention time.Duration
+	// HTTPTimeout limits the time of a request to the server including reading the response.
+	HTTPTimeout time.Duration
+	// TLS options of the connection to the server. Relative file paths are resolved in the profile folder.
+	TLSCAFile     string
+	TLSCertFile   string
+	TLSKeyFile    string
+	TLSMinVersion string
+	// TLSPins are SPKI pins of the server certificate or one of its issuers, see ParseSPKIPin.
+	TLSPins []string
+	// Proxy is url of HTTP or SOCKS5 proxy, the proxy is taken from environment variables if it's empty.
+	Proxy string
+	// RateLimit is the number of requests per second to the server, 0 means no limit. RateBurst is the number
+	// of requests, which could be sent at once.
+	RateLimit float64
+	RateBurst int
+	// sources keeps where the option value is taken from, by option key.
+	sources map[string]string
+	// homeDir and args are kept to reload configuration with another profile.
+	homeDir string
+	args    []string
+}
+
+// configOption binds configuration key (used in the file, environment variable and flag) to the Config field.
+type configOption struct {
+	Key   string
+	Usage string
+	// perProfile options are set in the profile section of the configuration file, top level values
+	// belong to the default profile.
+	perProfile bool
+	get        func(config *Config) string
+	set        func(config *Config, value string) error
+}
+
+// configOptions lists options, which could be set in the configuration file, environment variables or flags.
+var configOptions = []configOption{
+	{
+		Key:        "api_host",
+		perProfile: true,
+		Usage:      "links manager server API url or unix:///path/to/socket",
+		get:        func(config *Config) string { return config.APIHost },
+		set: func(config *Config, value string) error {
+			if _, ok := unixSocket(value); ok {
+				err := validateSocket(value)
+				if err != nil {
+					return err
+				}
+				config.APIHost = strings.TrimSuffix(value, "/") + "/"
+				return nil
+			}
+			u, err := url.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("api_host should be http(s) url or %s:///path/to/socket, given %q", UnixScheme, value)
+			}
+			if !strings.HasSuffix(value, "/") {
+				value += "/"
+			}
+			config.APIHost = value
+			return nil
+		},
+	},
+	{
+		Key:        "auth_token_filename",
+		perProfile: true,
+		Usage:      "name of the file in the configuration folder to save authentication token",
+		get:        func(config *Config) string { return config.AuthTokenFilename },
+		set: func(config *Config, value string) error {
+			return setFilename(&config.AuthTokenFilename, "auth_token_filename", value)
+		},
+	},
+	{
+		Key:        "credentials_filename",
+		perProfile: true,
+		Usage:      "name of the file in the configuration folder to save credentials",
+		get:        func(config *Config) string { return config.CredentialsFilename },
+		set: func(config *Config, value string) error {
+			return setFilename(&config.CredentialsFilename, "credentials_filename", value)
+		},
+	},
+	{
+		Key:   "log_filename",
+		Usage: "name of the log file in the configuration folder",
+		get:   func(config *Config) string { return config.LogFilename },
+		set: func(config *Config, value string) error {
+			return setFilename(&config.LogFilename, "log_filename", value)
+		},
+	},
+	{
+		Key:        "storage_name",
+		perProfile: true,
+		Usage:      "name of the sqlite db file in the configuration folder",
+		get:        func(config *Config) string { return config.StorageName },
+		set: func(config *Config, value string) error {
+			return setFilename(&config.StorageName, "storage_name", value)
+		},
+	},
+	{
+		Key:   "credentials_store",
+		Usage: "where to save credentials: auto, keyring, encrypted-file or file",
+		get:   func(config *Config) string { return config.CredentialsStore },
+		set: func(config *Config, value string) error {
+			switch value {
+			case CredentialsStoreAuto, CredentialsStoreKeyring, CredentialsStoreEncrypted, CredentialsStoreFile:
+				config.CredentialsStore = value
+				return nil
+			}
+			return fmt.Errorf("credentials_store should be one of auto, keyring, encrypted-file or file, given %q", value)
+		},
+	},
+	{
+		Key:   "http_timeout",
+		Usage: "time limit of a request to the server, e.g. 30s",
+		get:   func(config *Config) string { return config.HTTPTimeout.String() },
+		set: func(config *Config, value string) error {
+			return setDuration(&config.HTTPTimeout, "http_timeout", value)
+		},
+	},
+	{
+		Key:        "tls_ca_file",
+		perProfile: true,
+		Usage:      "PEM file with CA certificates to verify the server certificate instead of the system ones",
+		get:        func(config *Config) string { return config.TLSCAFile },
+		set: func(config *Config, value string) error {
+			config.TLSCAFile = value
+			return nil
+		},
+	},
+	{
+		Key:        "tls_cert_file",
+		perProfile: true,
+		Usage:      "PEM file with the client certificate, requires tls_key_file",
+		get:        func(config *Config) string { return config.TLSCertFile },
+		set: func(config *Config, value string) error {
+			config.TLSCertFile = value
+			return nil
+		},
+	},
+	{
+		Key:        "tls_key_file",
+		perProfile: true,
+		Usage:      "PEM file with the private key of the client certificate",
+		get:        func(config *Config) string { return config.TLSKeyFile },
+		set: func(config *Config, value string) error {
+			config.TLSKeyFile = value
+			return nil
+		},
+	},
+	{
+		Key:        "tls_min_version",
+		perProfile: true,
+		Usage:      "minimum TLS version: 1.0, 1.1, 1.2 or 1.3",
+		get:        func(config *Config) string { return config.TLSMinVersion },
+		set: func(config *Config, value string) error {
+			_, err := ParseTLSVersion(value)
+			if err != nil {
+				return err
+			}
+			config.TLSMinVersion = value
+			return nil
+		},
+	},
+	{
+		Key:        "tls_pin",
+		perProfile: true,
+		Usage:      "comma separated SPKI pins of the server certificate or its issuer, e.g. sha256/<base64>",
+		get:        func(config *Config) string { return strings.Join(config.TLSPins, ",") },
+		set: func(config *Config, value string) error {
+			pins := []string{}
+			for _, pin := range strings.Split(value, ",") {
+				pin = strings.TrimSpace(pin)
+				if pin == "" {
+					continue
+				}
+				_, err := ParseSPKIPin(pin)
+				if err != nil {
+					return err
+				}
+				pins = append(pins, pin)
+			}
+			config.TLSPins = pins
+			return nil
+		},
+	},
+	{
+		Key:        "proxy",
+		perProfile: true,
+		Usage:      "HTTP or SOCKS5 proxy url, e.g. socks5://localhost:1080, direct to ignore HTTPS_PROXY and HTTP_PROXY",
+		get:        func(config *Config) string { return config.Proxy },
+		set: func(config *Config, value string) error {
+			if value != "" && value != ProxyDirect {
+				err := validateProxy(value)
+				if err != nil {
+					return err
+				}
+			}
+			config.Proxy = value
+			return nil
+		},
+	},
+	{
+		Key:        "rate_limit",
+		perProfile: true,
+		Usage:      "maximum number of requests per second to the server, 0 means no limit",
+		get:        func(config *Config) string { return strconv.FormatFloat(config.RateLimit, 'g', -1, 64) },
+		set: func(config *Config, value string) error {
+			limit, err := strconv.ParseFloat(value, 64)
+			if err != nil || limit < 0 || math.IsInf(limit, 0) {
+				return fmt.Errorf("rate_limit should be a not negative number, given %q", value)
+			}
+			config.RateLimit = limit
+			return nil
+		},
+	},
+	{
+		Key:        "rate_burst",
+		perProfile: true,
+		Usage:      "number of requests, which could be sent at once without waiting for rate_limit",
+		get:        func(config *Config) string { return strconv.Itoa(config.RateBurst) },
+		set: func(config *Config, value string) error {
+			burst, err := strconv.Atoi(value)
+			if err != nil || burst < 1 {
+				return fmt.Errorf("rate_burst should be a positive integer, given %q", value)
+			}
+			config.RateBurst = burst
+			return nil
+		},
+	},
+	{
+		Key:   "backoff_initial",
+		Usage: "delay before the second attempt to reach the server or to send a failed job, e.g. 1s",
+		get:   func(config *Config) string { return config.BackoffInitial.String() },
+		set: func(config *Config, value string) error {
+			return setDuration(&config.BackoffInitial, "backoff_initial", value)
+		},
+	},
+	{
+		Key:   "backoff_max",
+		Usage: "the longest delay between attempts, e.g. 5m",
+		get:   func(config *Config) string { return config.BackoffMax.String() },
+		set: func(config *Config, value string) error {
+			return setDuration(&config.BackoffMax, "backoff_max", value)
+		},
+	},
+	{
+		Key:   "backoff_multiplier",
+		Usage: "the delay is multiplied by it after each attempt",
+		get:   func(config *Config) string { return strconv.FormatFloat(config.BackoffMultiplier, 'g', -1, 64) },
+		set: func(config *Config, value string) error {
+			multiplier, err := strconv.ParseFloat(value, 64)
+			if err != nil || multiplier < 1 {
+				return fmt.Errorf("backoff_multiplier should be a number not less than 1, given %q", value)
+			}
+			config.BackoffMultiplier = multiplier
+			return nil
+		},
+	},
+	{
+		Key:   "backoff_jitter",
+		Usage: "random part of the delay from 0 to 1, e.g. 0.2 changes the delay by up to 20%",
+		get:   func(config *Config) string { return strconv.FormatFloat(config.BackoffJitter, 'g', -1, 64) },
+		set: func(config *Config, value string) error {
+			jitter, err := strconv.ParseFloat(value, 64)
+			if err != nil || jitter < 0 || jitter > 1 {
+				return fmt.Errorf("backoff_jitter should be a number from 0 to 1, given %q", value)
+			}
+			config.BackoffJitter = jitter
+			return nil
+		},
+	},
+	{
+		Key:   "jobs_retention",
+		Usage: "time done jobs are kept after they are sent, e.g. 168h",
+		get:   func(config *Config) string { return config.JobsRetention.String() },
+		set: func(config *Config, value string) error {
+			return setDuration(&config.JobsRetention, "jobs_retention", value)
+		},
+	},
+}
+
+// setDuration validates the value is a positive duration.
+func setDuration(field *time.Duration, key, value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("%s should be a positive duration like 1s or 5m, given %q", key, value)
+	}
+	*field = d
+	return nil
+}
+
+// setFilename validates the value is a plain file name, so it's resolved inside the configuration folder.
+func setFilename(field *string, key, value string) error {
+	if value == "" || strings.ContainsRune(value, filepath.Separator) || value == "." || value == ".." {
+		return fmt.Errorf("%s should be a file name without folder, given %q", key, value)
+	}
+	*field = value
+	return nil
+}
+
+// findConfigOption returns option by key, nil if key is unknown.
+func findConfigOption(key string) *configOption {
+	for i := range configOptions {
+		if configOptions[i].Key == key {
+			return &configOptions[i]
+		}
+	}
+	return nil
+}
+
+// envName returns environment variable name of the option key, e.g. LMC_API_HOST.
+func envName(key string) string {
+	return EnvPrefix + strings.ToUpper(key)
+}
+
+// flagName returns command line flag name of the option key, e.g. api-host.
+func flagName(key string) string {
+	return strings.Replace(key, "_", "-", -1)
+}
+
+// DefaultConfig returns configuration with default values and folder in the user home directory.
+func DefaultConfig(homeDir string) *Config {
+	config := &Config{
+		Dir:                 homeDir + string(filepath.Separator) + ".lmc",
+		Profile:             DefaultProfile,
+		AuthTokenFilename:   "auth.token",
+		CredentialsFilename: "credentials",
+		APIHost:             "http://localhost:8080/api/",
+		LogFilename:         "links-manager-client.log",
+		StorageName:         "lmc.db",
+		CredentialsStore:    CredentialsStoreAuto,
+		BackoffInitial:      time.Second,
+		BackoffMax:          5 * time.Minute,
+		BackoffMultiplier:   2,
+		BackoffJitter:       0.2,
+		JobsRetention:       7 * 24 * time.Hour,
+		HTTPTimeout:         30 * time.Second,
+		TLSMinVersion:       "1.2",
+		RateBurst:           1,
+		sources:             map[string]string{},
+		homeDir:             homeDir,
+	}
+	for _, option := range configOptions {
+		config.sources[option.Key] = SourceDefault
+	}
+
+	return config
+}
+
+// LoadConfig builds configuration from defaults, the configuration file, LMC_* environment variables and
+// command line flags, each next one overrides the previous. Folder with configuration file is set by -dir flag
+// or LMC_DIR variable, profile is set by -profile flag, LMC_PROFILE variable or profile option of the file.
+// Returns the arguments left after flags.
+func LoadConfig(homeDir string, args []string, stderr io.Writer) (*Config, []string, error) {
+	return loadConfig(homeDir, args, stderr, "")
+}
+
+// WithProfile loads configuration of another profile. Per-profile options given by environment variables and
+// flags are not applied, as they were meant for the profile selected on start.
+func (config *Config) WithProfile(name string) (*Config, error) {
+	profileConfig, _, err := loadConfig(config.homeDir, config.args, ioutil.Discard, name)
+	return profileConfig, err
+}
+
+func loadConfig(homeDir string, args []string, stderr io.Writer, profile string) (*Config, []string, error) {
+	config := DefaultConfig(homeDir)
+
+	flags := flag.NewFlagSet("lmc", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: lmc [flags] [command [arguments]]")
+		fmt.Fprintln(stderr, "Without command starts the console, type help in the console to see the list of commands.")
+		fmt.Fprintf(stderr, "Flags override %s* environment variables, which override %s values.\n", EnvPrefix, ConfigFilename)
+		flags.PrintDefaults()
+	}
+	dir := flags.String("dir", "", "configuration folder (default ~/.lmc)")
+	profileFlag := flags.String("profile", "", "server profile (default "+DefaultProfile+")")
+	values := map[string]*string{}
+	for _, option := range configOptions {
+		values[option.Key] = flags.String(flagName(option.Key), "", option.Usage)
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.args = args[:len(args)-flags.NArg()]
+
+	if v := os.Getenv(EnvPrefix + "DIR"); v != "" {
+		config.Dir = v
+	}
+	if *dir != "" {
+		config.Dir = *dir
+	}
+
+	// Configuration file
+	fileValues, err := readConfigFile(config.ConfigPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	forced := profile != ""
+	if !forced {
+		profile = DefaultProfile
+		if v, ok := fileValues["profile"]; ok {
+			profile = fmt.Sprint(v)
+		}
+		if v := os.Getenv(EnvPrefix + "PROFILE"); v != "" {
+			profile = v
+		}
+		if *profileFlag != "" {
+			profile = *profileFlag
+		}
+	}
+	err = validateProfileName(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	config.Profile = profile
+	for key, value := range fileValues {
+		if key == "profile" || key == "profiles" {
+			continue
+		}
+		option := findConfigOption(key)
+		if option == nil {
+			return nil, nil, fmt.Errorf("%s: unknown option %s", config.ConfigPath(), key)
+		}
+		// Top level per-profile options belong to the default profile only
+		if option.perProfile && profile != DefaultProfile {
+			continue
+		}
+		err = config.set(option, fmt.Sprint(value), SourceFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", config.ConfigPath(), err.Error())
+		}
+	}
+	if profile != DefaultProfile {
+		section, ok := profileSections(fileValues)[profile]
+		if !ok {
+			return nil, nil, fmt.Errorf("Profile %s is not configured, add it with profile add command", profile)
+		}
+		if _, ok := section["api_host"]; !ok {
+			return nil, nil, fmt.Errorf("%s: api_host of profile %s is not set", config.ConfigPath(), profile)
+		}
+		for key, value := range section {
+			option := findConfigOption(key)
+			if option == nil || !option.perProfile {
+				return nil, nil, fmt.Errorf("%s: unknown option %s of profile %s", config.ConfigPath(), key, profile)
+			}
+			err = config.set(option, fmt.Sprint(value), SourceFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: profile %s: %s", config.ConfigPath(), profile, err.Error())
+			}
+		}
+	}
+
+	// Environment variables
+	for i := range configOptions {
+		if forced && configOptions[i].perProfile {
+			continue
+		}
+		if value, ok := os.LookupEnv(envName(configOptions[i].Key)); ok {
+			err = config.set(&configOptions[i], value, SourceEnv)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %s", envName(configOptions[i].Key), err.Error())
+			}
+		}
+	}
+
+	// Flags, only explicitly given
+	flags.Visit(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		for i := range configOptions {
+			if forced && configOptions[i].perProfile {
+				continue
+			}
+			if flagName(configOptions[i].Key) == f.Name {
+				err = config.set(&configOptions[i], *values[configOptions[i].Key], SourceFlag)
+				if err != nil {
+					err = fmt.Errorf("-%s: %s", f.Name, err.Error())
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return config, flags.Args(), nil
+}
+
+// validateProfileName allows letters, digits, dash and underscore, as the name is used as a folder name.
+func validateProfileName(name string) error {
+	if name == "" {
+		return fmt.Errorf("Profile name is empty")
+	}
+	for _, c := range name {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '-' && c != '_' {
+			return fmt.Errorf("Profile name %q should contain only letters, digits, - and _", name)
+		}
+	}
+	return nil
+}
+
+// profileSections returns [profiles.<name>] tables of the configuration file.
+func profileSections(values map[string]interface{}) map[string]map[string]interface{} {
+	sections := map[string]map[string]interface{}{}
+	if profiles, ok := values["profiles"].(map[string]interface{}); ok {
+		for name, section := range profiles {
+			if section, ok := section.(map[string]interface{}); ok {
+				sections[name] = section
+			}
+		}
+	}
+	return sections
+}
+
+// readConfigFile reads options from the file, empty map if the file doesn't exist.
+func readConfigFile(filename string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	_, err := toml.DecodeFile(filename, &values)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Reading configuration file %s failed: %s", filename, err.Error())
+	}
+
+	return values, nil
+}
+
+// writeConfigFile replaces the configuration file, so it's never left partly written.
+func writeConfigFile(filename string, values map[string]interface{}) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), ConfigFilename)
+	if err != nil {
+		return fmt.Errorf("Writing configuration file failed: %s", err.Error())
+	}
+	defer os.Remove(f.Name())
+	err = toml.NewEncoder(f).Encode(values)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("Writing configuration file failed: %s", err.Error())
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("Writing configuration file failed: %s", err.Error())
+	}
+	err = os.Rename(f.Name(), filename)
+	if err != nil {
+		return fmt.Errorf("Writing configuration file failed: %s", err.Error())
+	}
+
+	return nil
+}
+
+// set validates and sets option value.
+func (config *Config) set(option *configOption, value, source string) error {
+	err := option.set(config, value)
+	if err != nil {
+		return err
+	}
+	if config.sources == nil {
+		config.sources = map[string]string{}
+	}
+	config.sources[option.Key] = source
+
+	return nil
+}
+
+// Show writes effective options with their sources.
+func (config *Config) Show(out io.Writer) {
+	fmt.Fprintf(out, "%s = %q # %s\n", "dir", config.Dir, "flag -dir or "+EnvPrefix+"DIR")
+	fmt.Fprintf(out, "%s = %q\n", "profile", config.Profile)
+	for _, option := range configOptions {
+		fmt.Fprintf(out, "%s = %q # %s\n", option.Key, option.get(config), config.sources[option.Key])
+	}
+}
+
+// Save validates option value, sets it and writes to the configuration file. Per-profile options of
+// not default profile are written to the profile section. It returns source of the effective value,
+// which is different from file if the option is overridden.
+func (config *Config) Save(key, value string) (string, error) {
+	option := findConfigOption(key)
+	if option == nil {
+		keys := []string{}
+		for _, option := range configOptions {
+			keys = append(keys, option.Key)
+		}
+		sort.Strings(keys)
+		return "", fmt.Errorf("Unknown option %s, expected one of: %s", key, strings.Join(keys, ", "))
+	}
+	// Validate value before writing
+	err := option.set(&Config{}, value)
+	if err != nil {
+		return "", err
+	}
+	values, err := readConfigFile(config.ConfigPath())
+	if err != nil {
+		return "", err
+	}
+	if option.perProfile && config.Profile != DefaultProfile {
+		section, ok := profileSections(values)[config.Profile]
+		if !ok {
+			return "", fmt.Errorf("Profile %s is not configured", config.Profile)
+		}
+		section[key] = value
+	} else {
+		values[key] = value
+	}
+	err = writeConfigFile(config.ConfigPath(), values)
+	if err != nil {
+		return "", err
+	}
+
+	source := config.sources[key]
+	if source == SourceDefault || source == SourceFile {
+		err = config.set(option, value, SourceFile)
+		if err != nil {
+			return "", err
+		}
+		source = SourceFile
+	}
+
+	return source, nil
+}
+
+// Profiles returns names of the configured profiles including the default one.
+func (config *Config) Profiles() ([]string, error) {
+	values, err := readConfigFile(config.ConfigPath())
+	if err != nil {
+		return nil, err
+	}
+	names := []string{DefaultProfile}
+	for name := range profileSections(values) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// AddProfile writes new profile section with API host to the configuration file.
+func (config *Config) AddProfile(name, apiHost string) error {
+	err := validateProfileName(name)
+	if err != nil {
+		return err
+	}
+	err = findConfigOption("api_host").set(&Config{}, apiHost)
+	if err != nil {
+		return err
+	}
+	values, err := readConfigFile(config.ConfigPath())
+	if err != nil {
+		return err
+	}
+	sections := profileSections(values)
+	if _, ok := sections[name]; ok || name == DefaultProfile {
+		return fmt.Errorf("Profile %s already exists", name)
+	}
+	profiles, ok := values["profiles"].(map[string]interface{})
+	if !ok {
+		profiles = map[string]interface{}{}
+		values["profiles"] = profiles
+	}
+	profiles[name] = map[string]interface{}{"api_host": apiHost}
+
+	return writeConfigFile(config.ConfigPath(), values)
+}
+
+// SaveProfile writes the profile to use on the next start to the configuration file.
+func (config *Config) SaveProfile(name string) error {
+	values, err := readConfigFile(config.ConfigPath())
+	if err != nil {
+		return err
+	}
+	values["profile"] = name
+
+	return writeConfigFile(config.ConfigPath(), values)
+}
+
+// ProfileDir returns folder of the profile files, the default profile uses the configuration folder.
+func (config *Config) ProfileDir() string {
+	if config.Profile == "" || config.Profile == DefaultProfile {
+		return config.Dir
+	}
+	return filepath.Join(config.Dir, "profiles", config.Profile)
+}
+
+// resolvePath returns the path as it is if it's absolute, otherwise the path in the profile folder.
+func (config *Config) resolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(config.ProfileDir(), path)
+}
+
+// Backoff returns the backoff policy of the configuration.
+func (config *Config) Backoff() *Backoff {
+	return &Backoff{
+		Initial:    config.BackoffInitial,
+		Max:        config.BackoffMax,
+		Multiplier: config.BackoffMultiplier,
+		Jitter:     config.BackoffJitter,
+	}
+}
+
+// RateLimiter returns the limiter of requests to the server. BackoffInitial is the pause if the server doesn't tell
+// how long to wait. A request waits for the pause up to HTTPTimeout, so a job never holds a processor longer
+// than the request could take.
+func (config *Config) RateLimiter() *RateLimiter {
+	limiter := NewRateLimiter(config.RateLimit, config.RateBurst)
+	limiter.DefaultPause = config.BackoffInitial
+	limiter.MaxWait = config.HTTPTimeout
+	return limiter
+}
+
+// ConfigPath returns path to the configuration file
+func (config *Config) ConfigPath() string {
+	return config.Dir + string(filepath.Separator) + ConfigFilename
 }
 
 // CredentialsPath returns path to the credentials file
 func (config *Config) CredentialsPath() string {
-	return config.Dir + string(filepath.Separator) + config.CredentialsFilename
+	return config.ProfileDir() + string(filepath.Separator) + config.CredentialsFilename
 }
 
 // AuthTokenPath returns path to the authentication toke file
 func (config *Config) AuthTokenPath() string {
-	return config.Dir + string(filepath.Separator) + config.AuthTokenFilename
+	return config.ProfileDir() + string(filepath.Separator) + config.AuthTokenFilename
 }
 
 // LogPath returns path to the authentication toke file
@@ -29,5 +784,5 @@ func (config *Config) LogPath() string {
 
 // StoragePath returns path to the authentication toke file
 func (config *Config) StoragePath() string {
-	return config.Dir + string(filepath.Separator) + config.StorageName
+	return config.ProfileDir() + string(filepath.Separator) + config.StorageName
 }
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, ConfigFilename), []byte(`
+api_host = "http://file.example.com/api/"
+log_filename = "file.log"
+storage_name = "file.db"
+`), 0600)
+	if err != nil {
+		t.Fatalf("[TestLoadConfigPrecedence] Unable to write config file: %s", err.Error())
+	}
+	t.Setenv("LMC_DIR", dir)
+	t.Setenv("LMC_LOG_FILENAME", "env.log")
+	t.Setenv("LMC_STORAGE_NAME", "env.db")
+
+	config, args, err := LoadConfig("/home/user", []string{"-storage-name", "flag.db", "ls", "-x"}, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("[TestLoadConfigPrecedence] Unable to load config: %s", err.Error())
+	}
+	if strings.Join(args, " ") != "ls -x" {
+		t.Errorf("[TestLoadConfigPrecedence] args Expected=ls -x;Actual=%v;", args)
+	}
+	expected := map[string][2]string{
+		"api_host":            {"http://file.example.com/api/", SourceFile},
+		"log_filename":        {"env.log", SourceEnv},
+		"storage_name":        {"flag.db", SourceFlag},
+		"auth_token_filename": {"auth.token", SourceDefault},
+	}
+	for key, value := range expected {
+		actual := findConfigOption(key).get(config)
+		if actual != value[0] || config.sources[key] != value[1] {
+			t.Errorf("[TestLoadConfigPrecedence] %s Expected=%s (%s);Actual=%s (%s);", key, value[0], value[1], actual, config.sources[key])
+		}
+	}
+	if config.StoragePath() != filepath.Join(dir, "flag.db") {
+		t.Errorf("[TestLoadConfigPrecedence] Unexpected storage path %s", config.StoragePath())
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	t.Setenv("LMC_DIR", t.TempDir())
+	cases := [][]string{
+		{"-api-host", "localhost:8080"},
+		{"-api-host", "ftp://localhost/"},
+		{"-storage-name", "../lmc.db"},
+		{"-log-filename", ""},
+		{"-unknown", "value"},
+		{"-backoff-initial", "1"},
+		{"-backoff-max", "-1s"},
+		{"-backoff-multiplier", "0.5"},
+		{"-backoff-jitter", "2"},
+		{"-tls-min-version", "1.4"},
+		{"-tls-pin", "sha1/abc"},
+		{"-tls-pin", "sha256/dG9vIHNob3J0"},
+		{"-rate-limit", "-1"},
+		{"-rate-limit", "fast"},
+		{"-rate-burst", "0"},
+	}
+	for _, args := range cases {
+		_, _, err := LoadConfig("/home/user", args, ioutil.Discard)
+		if err == nil {
+			t.Errorf("[TestLoadConfigValidation] %v error expected", args)
+		}
+	}
+
+	t.Setenv("LMC_API_HOST", "not a url")
+	_, _, err := LoadConfig("/home/user", nil, ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "LMC_API_HOST") {
+		t.Errorf("[TestLoadConfigValidation] Error should name the variable, given %v", err)
+	}
+}
+
+func TestConfigSave(t *testing.T) {
+	t.Setenv("LMC_DIR", t.TempDir())
+	t.Setenv("LMC_LOG_FILENAME", "env.log")
+	config, _, err := LoadConfig("/home/user", nil, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("[TestConfigSave] Unable to load config: %s", err.Error())
+	}
+
+	source, err := config.Save("api_host", "https://links.example.com/api")
+	if err != nil || source != SourceFile {
+		t.Errorf("[TestConfigSave] Save failed: %v %s", err, source)
+	}
+	if config.APIHost != "https://links.example.com/api/" {
+		t.Errorf("[TestConfigSave] Saved value is not applied: %s", config.APIHost)
+	}
+	source, err = config.Save("log_filename", "file.log")
+	if err != nil || source != SourceEnv {
+		t.Errorf("[TestConfigSave] Overridden option should be reported: %v %s", err, source)
+	}
+	if _, err = config.Save("api_host", "localhost"); err == nil {
+		t.Errorf("[TestConfigSave] Invalid value should not be saved")
+	}
+	if _, err = config.Save("unknown", "value"); err == nil {
+		t.Errorf("[TestConfigSave] Unknown option should not be saved")
+	}
+
+	loaded, _, err := LoadConfig("/home/user", nil, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("[TestConfigSave] Unable to load saved config: %s", err.Error())
+	}
+	if loaded.APIHost != "https://links.example.com/api/" || loaded.LogFilename != "env.log" {
+		t.Errorf("[TestConfigSave] Unexpected loaded config %v", loaded)
+	}
+	var out bytes.Buffer
+	loaded.Show(&out)
+	if !strings.Contains(out.String(), `api_host = "https://links.example.com/api/" # file`) {
+		t.Errorf("[TestConfigSave] Unexpected show output %s", out.String())
+	}
+}
+
+func TestConfigProfiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("LMC_DIR", dir)
+	config, _, err := LoadConfig("/home/user", nil, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("[TestConfigProfiles] Unable to load config: %s", err.Error())
+	}
+	if config.Profile != DefaultProfile || config.StoragePath() != filepath.Join(dir, "lmc.db") {
+		t.Errorf("[TestConfigProfiles] Default profile should use configuration folder, given %s", config.StoragePath())
+	}
+	if _, err = config.Save("api_host", "https://dev.example.com/api/"); err != nil {
+		t.Fatalf("[TestConfigProfiles] Save failed: %s", err.Error())
+	}
+	if err = config.AddProfile("staging", "https://staging.example.com/api/"); err != nil {
+		t.Fatalf("[TestConfigProfiles] AddProfile failed: %s", err.Error())
+	}
+	if err = config.AddProfile("staging", "https://staging.example.com/api/"); err == nil {
+		t.Errorf("[TestConfigProfiles] Adding existing profile should fail")
+	}
+	if err = config.AddProfile("../prod", "https://prod.example.com/api/"); err == nil {
+		t.Errorf("[TestConfigProfiles] Profile name with path should be rejected")
+	}
+	if _, _, err = LoadConfig("/home/user", []string{"-profile", "prod"}, ioutil.Discard); err == nil {
+		t.Errorf("[TestConfigProfiles] Not configured profile should be rejected")
+	}
+
+	staging, _, err := LoadConfig("/home/user", []string{"-profile", "staging"}, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("[TestConfigProfiles] Unable to load profile: %s", err.Error())
+	}
+	if staging.APIHost != "https://staging.example.com/api/" {
+		t.Errorf("[TestConfigProfiles] Profile should not inherit default profile api_host, given %s", staging.APIHost)
+	}
+	if staging.StoragePath() != filepath.Join(dir, "profiles", "staging", "lmc.db") ||
+		staging.AuthTokenPath() != filepath.Join(dir, "profiles", "staging", "auth.token") {
+		t.Errorf("[TestConfigProfiles] Profile files should be in the profile folder, given %s", staging.StoragePath())
+	}
+	if _, err = staging.Save("storage_name", "staging.db"); err != nil {
+		t.Fatalf("[TestConfigProfiles] Save to profile failed: %s", err.Error())
+	}
+	if err = staging.SaveProfile("staging"); err != nil {
+		t.Fatalf("[TestConfigProfiles] SaveProfile failed: %s", err.Error())
+	}
+
+	current, _, err := LoadConfig("/home/user", nil, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("[TestConfigProfiles] Unable to load config: %s", err.Error())
+	}
+	if current.Profile != "staging" || current.StorageName != "staging.db" {
+		t.Errorf("[TestConfigProfiles] Saved profile should be used, given %s %s", current.Profile, current.StorageName)
+	}
+	names, err := current.Profiles()
+	if err != nil || strings.Join(names, ",") != "default,staging" {
+		t.Errorf("[TestConfigProfiles] Unexpected profiles %v %v", names, err)
+	}
+
+	// Per-profile overrides given on start must not leak into another profile
+	t.Setenv("LMC_API_HOST", "https://override.example.com/api/")
+	overridden, _, err := LoadConfig("/home/user", []string{"-profile", "default"}, ioutil.Discard)
+	if err != nil || overridden.APIHost != "https://override.example.com/api/" {
+		t.Fatalf("[TestConfigProfiles] Unable to load overridden config: %v", err)
+	}
+	switched, err := overridden.WithProfile("staging")
+	if err != nil {
+		t.Fatalf("[TestConfigProfiles] WithProfile failed: %s", err.Error())
+	}
+	if switched.APIHost != "https://staging.example.com/api/" {
+		t.Errorf("[TestConfigProfiles] Switched profile should use own api_host, given %s", switched.APIHost)
+	}
+}
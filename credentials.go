@@ -0,0 +1,368 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// KeyringService is the service name of credentials saved in the OS secret service.
+	KeyringService = "links-manager-client"
+	// EncryptedCredentialsExt is appended to the credentials filename by the encrypted file store.
+	EncryptedCredentialsExt = ".enc"
+)
+
+// Credential store types, selected by credentials_store option.
+const (
+	CredentialsStoreAuto      = "auto"
+	CredentialsStoreKeyring   = "keyring"
+	CredentialsStoreEncrypted = "encrypted-file"
+	CredentialsStoreFile      = "file"
+)
+
+// ErrNoCredentials is returned by a credential store if credentials are not saved yet.
+var ErrNoCredentials = errors.New("credentials are not saved")
+
+// CredentialStore saves and loads user credentials of a profile.
+type CredentialStore interface {
+	Load() (*UserCredentials, error)
+	Save(*UserCredentials) error
+	Delete() error
+}
+
+// NewCredentialStore creates store selected by the configuration. Auto store uses OS secret service if it's
+// available, otherwise falls back to the plaintext file. Passphrase is requested only by the encrypted file store.
+func NewCredentialStore(config *Config, passphrase func() (string, error)) (CredentialStore, error) {
+	switch config.CredentialsStore {
+	case CredentialsStoreKeyring:
+		return &KeyringStore{Service: KeyringService, User: config.ProfileDir()}, nil
+	case CredentialsStoreEncrypted:
+		return &EncryptedFileStore{Filename: config.CredentialsPath() + EncryptedCredentialsExt, Passphrase: passphrase}, nil
+	case CredentialsStoreFile:
+		return &FileStore{Filename: config.CredentialsPath()}, nil
+	case CredentialsStoreAuto, "":
+		store := &KeyringStore{Service: KeyringService, User: config.ProfileDir()}
+		if store.Available() {
+			return store, nil
+		}
+		return &FileStore{Filename: config.CredentialsPath()}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown credentials store %s", config.CredentialsStore)
+}
+
+// MigrateCredentials moves credentials from the legacy plaintext file to the store and removes the file.
+// It returns false if there is nothing to migrate.
+func MigrateCredentials(legacy *FileStore, store CredentialStore) (bool, error) {
+	if s, ok := store.(*FileStore); ok && s.Filename == legacy.Filename {
+		return false, nil
+	}
+	credentials, err := legacy.Load()
+	if err == ErrNoCredentials {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = store.Save(credentials)
+	if err != nil {
+		return false, fmt.Errorf("Migrating credentials failed: %s", err.Error())
+	}
+	err = legacy.Delete()
+	if err != nil {
+		return false, fmt.Errorf("Migrating credentials failed: %s", err.Error())
+	}
+
+	return true, nil
+}
+
+// KeyringStore keeps credentials in the OS secret service (Secret Service on Linux, Keychain on macOS,
+// Credential Manager on Windows).
+type KeyringStore struct {
+	Service string
+	User    string
+}
+
+// Available checks if the secret service could be used.
+func (store *KeyringStore) Available() bool {
+	_, err := keyring.Get(store.Service, store.User)
+	return err == nil || err == keyring.ErrNotFound
+}
+
+// Load implements CredentialStore interface.
+func (store *KeyringStore) Load() (*UserCredentials, error) {
+	secret, err := keyring.Get(store.Service, store.User)
+	if err == keyring.ErrNotFound {
+		return nil, ErrNoCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Reading credentials from keyring failed: %s", err.Error())
+	}
+	credentials := &UserCredentials{}
+	err = json.Unmarshal([]byte(secret), credentials)
+	if err != nil {
+		return nil, fmt.Errorf("Decoding credentials from keyring failed: %s", err.Error())
+	}
+
+	return credentials, nil
+}
+
+// Save implements CredentialStore interface.
+func (store *KeyringStore) Save(credentials *UserCredentials) error {
+	secret, err := json.Marshal(credentials)
+	if err != nil {
+		return fmt.Errorf("Encoding credentials failed: %s", err.Error())
+	}
+	err = keyring.Set(store.Service, store.User, string(secret))
+	if err != nil {
+		return fmt.Errorf("Saving credentials to keyring failed: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Delete implements CredentialStore interface.
+func (store *KeyringStore) Delete() error {
+	err := keyring.Delete(store.Service, store.User)
+	if err != nil && err != keyring.ErrNotFound {
+		return fmt.Errorf("Removing credentials from keyring failed: %s", err.Error())
+	}
+
+	return nil
+}
+
+// EncryptedFileStore keeps credentials in the file encrypted with AES-GCM, the key is derived from passphrase
+// with scrypt.
+type EncryptedFileStore struct {
+	Filename   string
+	Passphrase func() (string, error)
+	passphrase string
+}
+
+// encryptedCredentials is the file format of EncryptedFileStore.
+type encryptedCredentials struct {
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// Load implements CredentialStore interface.
+func (store *EncryptedFileStore) Load() (*UserCredentials, error) {
+	b, err := ioutil.ReadFile(store.Filename)
+	if os.IsNotExist(err) {
+		return nil, ErrNoCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read data from the credentials file: %s", err.Error())
+	}
+	encrypted := encryptedCredentials{}
+	err = json.Unmarshal(b, &encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode the credentials file: %s", err.Error())
+	}
+	aead, err := store.cipher(encrypted.Salt)
+	if err != nil {
+		return nil, err
+	}
+	data, err := aead.Open(nil, encrypted.Nonce, encrypted.Data, nil)
+	if err != nil {
+		// Ask passphrase again next time
+		store.passphrase = ""
+		return nil, fmt.Errorf("Failed to decrypt the credentials file: wrong passphrase or the file is corrupted")
+	}
+	credentials := &UserCredentials{}
+	err = json.Unmarshal(data, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode the credentials file: %s", err.Error())
+	}
+
+	return credentials, nil
+}
+
+// Save implements CredentialStore interface.
+func (store *EncryptedFileStore) Save(credentials *UserCredentials) error {
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		return fmt.Errorf("Encoding credentials failed: %s", err.Error())
+	}
+	encrypted := encryptedCredentials{Salt: make([]byte, 16)}
+	_, err = rand.Read(encrypted.Salt)
+	if err != nil {
+		return fmt.Errorf("Generating salt failed: %s", err.Error())
+	}
+	aead, err := store.cipher(encrypted.Salt)
+	if err != nil {
+		return err
+	}
+	encrypted.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(encrypted.Nonce)
+	if err != nil {
+		return fmt.Errorf("Generating nonce failed: %s", err.Error())
+	}
+	encrypted.Data = aead.Seal(nil, encrypted.Nonce, data, nil)
+	b, err := json.Marshal(encrypted)
+	if err != nil {
+		return fmt.Errorf("Encoding credentials failed: %s", err.Error())
+	}
+	err = ioutil.WriteFile(store.Filename, b, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to write data to the credentials file: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Delete implements CredentialStore interface.
+func (store *EncryptedFileStore) Delete() error {
+	err := os.Remove(store.Filename)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to remove the credentials file: %s", err.Error())
+	}
+
+	return nil
+}
+
+// cipher derives key from passphrase and salt. Passphrase is requested once.
+func (store *EncryptedFileStore) cipher(salt []byte) (cipher.AEAD, error) {
+	if store.passphrase == "" {
+		if store.Passphrase == nil {
+			return nil, fmt.Errorf("Passphrase is required to use the encrypted credentials file")
+		}
+		passphrase, err := store.Passphrase()
+		if err != nil {
+			return nil, fmt.Errorf("Reading passphrase failed: %s", err.Error())
+		}
+		if passphrase == "" {
+			return nil, fmt.Errorf("Passphrase is empty")
+		}
+		store.passphrase = passphrase
+	}
+
+	return newCipher(store.passphrase, salt)
+}
+
+// newCipher creates AES-GCM cipher with the key derived from passphrase and salt with scrypt.
+func newCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Deriving key failed: %s", err.Error())
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Creating cipher failed: %s", err.Error())
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// sealSecret encrypts the secret with the key derived from passphrase, the result is in the format
+// of EncryptedFileStore.
+func sealSecret(passphrase string, secret string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("Passphrase is empty")
+	}
+	encrypted := encryptedCredentials{Salt: make([]byte, 16)}
+	_, err := rand.Read(encrypted.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("Generating salt failed: %s", err.Error())
+	}
+	aead, err := newCipher(passphrase, encrypted.Salt)
+	if err != nil {
+		return nil, err
+	}
+	encrypted.Nonce = make([]byte, aead.NonceSize())
+	_, err = rand.Read(encrypted.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("Generating nonce failed: %s", err.Error())
+	}
+	encrypted.Data = aead.Seal(nil, encrypted.Nonce, []byte(secret), nil)
+
+	return json.Marshal(encrypted)
+}
+
+// openSecret decrypts the secret sealed by sealSecret.
+func openSecret(passphrase string, sealed []byte) (string, error) {
+	encrypted := encryptedCredentials{}
+	err := json.Unmarshal(sealed, &encrypted)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decode the secret: %s", err.Error())
+	}
+	aead, err := newCipher(passphrase, encrypted.Salt)
+	if err != nil {
+		return "", err
+	}
+	data, err := aead.Open(nil, encrypted.Nonce, encrypted.Data, nil)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decrypt the secret: wrong passphrase or the secret is corrupted")
+	}
+
+	return string(data), nil
+}
+
+// FileStore keeps credentials in the plaintext file as username:password, it's the legacy format.
+type FileStore struct {
+	Filename string
+}
+
+// Load implements CredentialStore interface.
+func (store *FileStore) Load() (*UserCredentials, error) {
+	c, err := ioutil.ReadFile(store.Filename)
+	if os.IsNotExist(err) {
+		return nil, ErrNoCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read data from the credentials file: %s", err.Error())
+	}
+	// Username could not contain colon, the rest is the password
+	parts := strings.SplitN(string(c), ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, ErrNoCredentials
+	}
+
+	return &UserCredentials{Username: parts[0], Password: parts[1]}, nil
+}
+
+// Save implements CredentialStore interface.
+func (store *FileStore) Save(credentials *UserCredentials) error {
+	if strings.Contains(credentials.Username, ":") {
+		return fmt.Errorf("Username should not contain colon")
+	}
+	f, err := os.Create(store.Filename)
+	if err != nil {
+		return fmt.Errorf("Failed to create credentials file: %s", err.Error())
+	}
+	defer f.Close()
+	err = f.Chmod(0600)
+	if err != nil {
+		return fmt.Errorf("Failed to set permissions to the credentials file: %s", err.Error())
+	}
+	err = f.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("Failed to clear credentials file: %s", err.Error())
+	}
+	_, err = f.WriteString(credentials.Username + ":" + credentials.Password)
+	if err != nil {
+		return fmt.Errorf("Failed to write data to the credentials file: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Delete implements CredentialStore interface.
+func (store *FileStore) Delete() error {
+	err := os.Remove(store.Filename)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to remove the credentials file: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// memoryStore is in-memory CredentialStore fake.
+type memoryStore struct {
+	credentials *UserCredentials
+}
+
+func (store *memoryStore) Load() (*UserCredentials, error) {
+	if store.credentials == nil {
+		return nil, ErrNoCredentials
+	}
+	return store.credentials, nil
+}
+
+func (store *memoryStore) Save(credentials *UserCredentials) error {
+	store.credentials = credentials
+	return nil
+}
+
+func (store *memoryStore) Delete() error {
+	store.credentials = nil
+	return nil
+}
+
+func TestFileStore(t *testing.T) {
+	store := &FileStore{Filename: filepath.Join(t.TempDir(), "credentials")}
+	if _, err := store.Load(); err != ErrNoCredentials {
+		t.Errorf("[TestFileStore] ErrNoCredentials expected, given %v", err)
+	}
+	err := store.Save(&UserCredentials{Username: "user", Password: "pass:with:colons"})
+	if err != nil {
+		t.Fatalf("[TestFileStore] Save failed: %s", err.Error())
+	}
+	credentials, err := store.Load()
+	if err != nil || credentials.Username != "user" || credentials.Password != "pass:with:colons" {
+		t.Errorf("[TestFileStore] Unexpected credentials %v %v", credentials, err)
+	}
+	info, err := os.Stat(store.Filename)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("[TestFileStore] Credentials file should be readable by owner only: %v", info.Mode())
+	}
+	if err = store.Save(&UserCredentials{Username: "us:er", Password: "pass"}); err == nil {
+		t.Errorf("[TestFileStore] Username with colon should be rejected")
+	}
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "credentials.enc")
+	asked := 0
+	store := &EncryptedFileStore{Filename: filename, Passphrase: func() (string, error) {
+		asked++
+		return "secret", nil
+	}}
+	err := store.Save(&UserCredentials{Username: "user", Password: "pass:word"})
+	if err != nil {
+		t.Fatalf("[TestEncryptedFileStore] Save failed: %s", err.Error())
+	}
+	b, _ := ioutil.ReadFile(filename)
+	for _, plain := range []string{"user", "pass:word"} {
+		if strings.Contains(string(b), plain) {
+			t.Errorf("[TestEncryptedFileStore] File contains plaintext %s", plain)
+		}
+	}
+	credentials, err := store.Load()
+	if err != nil || credentials.Username != "user" || credentials.Password != "pass:word" {
+		t.Errorf("[TestEncryptedFileStore] Unexpected credentials %v %v", credentials, err)
+	}
+	if asked != 1 {
+		t.Errorf("[TestEncryptedFileStore] Passphrase should be asked once, asked %d", asked)
+	}
+
+	wrong := &EncryptedFileStore{Filename: filename, Passphrase: func() (string, error) {
+		return "wrong", nil
+	}}
+	if _, err = wrong.Load(); err == nil {
+		t.Errorf("[TestEncryptedFileStore] Wrong passphrase should fail")
+	}
+}
+
+func TestMigrateCredentials(t *testing.T) {
+	legacy := &FileStore{Filename: filepath.Join(t.TempDir(), "credentials")}
+	store := &memoryStore{}
+	migrated, err := MigrateCredentials(legacy, store)
+	if err != nil || migrated {
+		t.Errorf("[TestMigrateCredentials] Nothing should be migrated: %v %v", migrated, err)
+	}
+
+	err = ioutil.WriteFile(legacy.Filename, []byte("user:pass"), 0600)
+	if err != nil {
+		t.Fatalf("[TestMigrateCredentials] Unable to write legacy file: %s", err.Error())
+	}
+	migrated, err = MigrateCredentials(legacy, store)
+	if err != nil || !migrated {
+		t.Errorf("[TestMigrateCredentials] Credentials should be migrated: %v %v", migrated, err)
+	}
+	if store.credentials == nil || store.credentials.Username != "user" || store.credentials.Password != "pass" {
+		t.Errorf("[TestMigrateCredentials] Unexpected migrated credentials %v", store.credentials)
+	}
+	if _, err = os.Stat(legacy.Filename); !os.IsNotExist(err) {
+		t.Errorf("[TestMigrateCredentials] Legacy file should be removed")
+	}
+
+	// Migration to the same file is a no-op
+	ioutil.WriteFile(legacy.Filename, []byte("user:pass"), 0600)
+	migrated, err = MigrateCredentials(legacy, &FileStore{Filename: legacy.Filename})
+	if err != nil || migrated {
+		t.Errorf("[TestMigrateCredentials] The same file should not be migrated: %v %v", migrated, err)
+	}
+}
+
+func TestSetupWithStore(t *testing.T) {
+	config := DefaultConfig(t.TempDir())
+	store := &memoryStore{credentials: &UserCredentials{Username: "user", Password: "pass"}}
+	credentials, err := setup(config, store, NewPrompt(strings.NewReader(""), ioutil.Discard))
+	if err != nil || credentials.Username != "user" {
+		t.Errorf("[TestSetupWithStore] Unexpected credentials %v %v", credentials, err)
+	}
+	if _, err = os.Stat(config.AuthTokenPath()); err != nil {
+		t.Errorf("[TestSetupWithStore] Token file should be created: %s", err.Error())
+	}
+
+	// Credentials are requested if the store is empty
+	store = &memoryStore{}
+	credentials, err = setup(config, store, NewPrompt(strings.NewReader("new user\n pass: word \n"), ioutil.Discard))
+	if err != nil || credentials.Username != "new user" || credentials.Password != " pass: word " {
+		t.Errorf("[TestSetupWithStore] Unexpected credentials %v %v", credentials, err)
+	}
+	if store.credentials == nil || *store.credentials != *credentials {
+		t.Errorf("[TestSetupWithStore] Credentials should be saved to the store, given %v", store.credentials)
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands.Register(&Command{
+		Name:    "add",
+		Usage:   "add <url> [#tag ...] [description]",
+		Summary: "Create new link. The command could be omitted, if the line starts with url.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			if len(args) == 0 {
+				return errWrongArgs
+			}
+			return app.addLink(args)
+		},
+	})
+	commands.Register(&Command{
+		Name:    "sync",
+		Usage:   "sync",
+		Summary: "Pull links changed on the server since the previous synchronisation to the local copy.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			n, err := syncItems(app.ctx(), app.Auth, app.Cache)
+			if err != nil {
+				return err
+			}
+			green.Fprintf(out, "Synchronised %d changes\n", n)
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "ls",
+		Aliases: []string{"list"},
+		Usage:   "ls",
+		Summary: "List links from the local copy.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			links, err := app.Cache.ReadAll()
+			if err != nil {
+				return err
+			}
+			for _, link := range links {
+				fmt.Fprintln(out, link)
+			}
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "search",
+		Usage:   `search <query>`,
+		Summary: `Search links in the local copy: #tag filters by tag, "two words" is a phrase, word* is a prefix.`,
+		Handler: func(app *App, args []string, out io.Writer) error {
+			if len(args) == 0 {
+				return errWrongArgs
+			}
+			links, err := app.Cache.Search(strings.Join(args, " "), 50)
+			if err != nil {
+				return err
+			}
+			for _, link := range links {
+				fmt.Fprintln(out, link)
+			}
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "tags",
+		Usage:   "tags",
+		Summary: "List tags with number of links, the local copy is used if the server is not available.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			if len(args) != 0 {
+				return errWrongArgs
+			}
+			tags, err := listTags(app.ctx(), app.Auth)
+			if IsUnavailable(err) {
+				tags, err = app.Cache.Tags()
+			}
+			if err != nil {
+				return err
+			}
+			for _, tag := range tags {
+				fmt.Fprintf(out, "#%s (%d)\n", tag.Name, tag.Count)
+			}
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "tag",
+		Usage:   "tag rename <old> <new> | tag merge <tag> ... into <tag> | tag rm <tag>",
+		Summary: "Rename, merge or remove tags of all links. Changes are queued and sent later if the server is not available.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			if len(args) == 0 {
+				return errWrongArgs
+			}
+			var change *TagChange
+			switch {
+			case args[0] == "rename" && len(args) == 3:
+				from, err := ParseTagName(args[1])
+				if err != nil {
+					return err
+				}
+				to, err := ParseTagName(args[2])
+				if err != nil {
+					return err
+				}
+				change = &TagChange{From: []string{from}, To: to}
+			case args[0] == "merge":
+				var err error
+				change, err = ParseTagMerge(args[1:])
+				if err != nil {
+					return err
+				}
+			case args[0] == "rm" && len(args) == 2:
+				name, err := ParseTagName(args[1])
+				if err != nil {
+					return err
+				}
+				change = &TagChange{From: []string{name}}
+			default:
+				return errWrongArgs
+			}
+
+			return app.changeTags(change)
+		},
+	})
+	commands.Register(&Command{
+		Name:    "show",
+		Usage:   "show <id>",
+		Summary: "Show link, the local copy is used if the server is not available.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			if len(args) != 1 {
+				return errWrongArgs
+			}
+			link, err := getLink(app.ctx(), app.Auth, args[0])
+			if err != nil {
+				// Fallback to the local copy if the server is not available
+				if IsUnavailable(err) {
+					link, err = app.Cache.Get(args[0])
+					if err == nil && link == nil {
+						err = fmt.Errorf("Link %s is not found in the local cache", args[0])
+					}
+				}
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, link)
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "edit",
+		Usage:   "edit <id> [url] [#tag ...] [description]",
+		Summary: "Change link, omitted url, tags or description are left as they are. Changes are queued like new links.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			if len(args) < 2 {
+				return errWrongArgs
+			}
+			return app.editLink(args[0], args[1:])
+		},
+	})
+	commands.Register(&Command{
+		Name:    "rm",
+		Aliases: []string{"delete"},
+		Usage:   "rm <id>",
+		Summary: "Remove link. The removal is queued like new links.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			if len(args) != 1 {
+				return errWrongArgs
+			}
+			return app.deleteLink(args[0])
+		},
+	})
+	commands.Register(&Command{
+		Name:    "ua",
+		Usage:   "ua",
+		Summary: "Create new user on the server. The user is queued like new links.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			if len(args) != 0 {
+				return errWrongArgs
+			}
+			return app.addUser()
+		},
+	})
+	commands.Register(&Command{
+		Name:    "user",
+		Usage:   "user [list] | user show|passwd|disable|enable|rm <id>",
+		Summary: "Manage users of the server: list, show, change password, disable, enable or remove the user. Admins only.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			switch {
+			case len(args) == 0 || len(args) == 1 && args[0] == "list":
+				users, err := listUsers(app.ctx(), app.Auth)
+				if err != nil {
+					return err
+				}
+				for _, user := range users {
+					fmt.Fprintln(out, user)
+				}
+			case len(args) == 2 && args[0] == "show":
+				user, err := getUser(app.ctx(), app.Auth, args[1])
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(out, user)
+			case len(args) == 2 && args[0] == "passwd":
+				err := changeUserPassword(app.ctx(), app.Auth, args[1], app.Prompt)
+				if err != nil {
+					return err
+				}
+				green.Fprintf(out, "Password of user %s changed\n", args[1])
+			case len(args) == 2 && (args[0] == "disable" || args[0] == "enable"):
+				_, err := setUserDisabled(app.ctx(), app.Auth, args[1], args[0] == "disable")
+				if err != nil {
+					return err
+				}
+				green.Fprintf(out, "User %s %sd\n", args[1], args[0])
+			case len(args) == 2 && args[0] == "rm":
+				ok, err := app.Prompt.Confirm(fmt.Sprintf("Remove user %s?", args[1]), false)
+				if err != nil || !ok {
+					return err
+				}
+				err = deleteUser(app.ctx(), app.Auth, args[1])
+				if err != nil {
+					return err
+				}
+				green.Fprintf(out, "User %s removed\n", args[1])
+			default:
+				return errWrongArgs
+			}
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "auth",
+		Usage:   "auth",
+		Summary: "Authenticate on the server with saved credentials.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			_, err := app.Auth.Authenticate(app.ctx())
+			if err != nil {
+				return err
+			}
+			green.Fprintln(out, "Authorised OK")
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "logout",
+		Usage:   "logout [--forget]",
+		Summary: "Revoke the token and remove it. With --forget the saved credentials are removed too.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			forget := false
+			for _, arg := range args {
+				if arg != "--forget" {
+					return errWrongArgs
+				}
+				forget = true
+			}
+			// The token is removed locally even if revoking fails, the failure is returned at the end
+			revoked, revokeErr := app.Auth.Logout(app.ctx())
+			if revoked {
+				green.Fprintln(out, "Token revoked")
+			} else {
+				blue.Fprintln(out, "Token removed, it's not revoked on the server")
+			}
+			if forget {
+				err := app.Credentials.Delete()
+				if err != nil {
+					return err
+				}
+				app.Auth.SetUserCredentials(&UserCredentials{})
+				blue.Fprintln(out, "Credentials removed")
+			}
+
+			return revokeErr
+		},
+	})
+	commands.Register(&Command{
+		Name:    "whoami",
+		Usage:   "whoami",
+		Summary: "Show the current user and when the authentication token expires.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			if len(args) != 0 {
+				return errWrongArgs
+			}
+			fmt.Fprintf(out, "User: %s\n", app.Auth.UserCredentials.Username)
+			fmt.Fprintf(out, "Server: %s (profile %s)\n", app.Config.APIHost, app.Config.Profile)
+			token, err := app.Auth.CurrentToken()
+			if err != nil {
+				return err
+			}
+			switch {
+			case token.Value == "":
+				blue.Fprintln(out, "Token: not authenticated")
+			case token.ExpiresAt.IsZero():
+				fmt.Fprintln(out, "Token: expiration is unknown")
+			case token.ExpiresAt.Before(time.Now()):
+				red.Fprintf(out, "Token: expired at %s\n", token.ExpiresAt.Local().Format(time.RFC1123))
+			default:
+				fmt.Fprintf(out, "Token: expires at %s (in %s)\n", token.ExpiresAt.Local().Format(time.RFC1123),
+					time.Until(token.ExpiresAt).Round(time.Second))
+			}
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "credentials",
+		Usage:   "credentials",
+		Summary: "Change saved credentials.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			username, password, err := readAndSaveUserCredentials(app.Prompt, app.Credentials)
+			if err != nil {
+				return err
+			}
+			app.Auth.SetUserCredentials(&UserCredentials{Username: username, Password: password})
+			blue.Fprintln(out, "Credentials saved")
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "jobs",
+		Usage:   "jobs [pending|in-flight|done|failed] | jobs show <id> | jobs dead | jobs retry <id> | jobs purge [--done]",
+		Summary: "List jobs with their state or show the job. Dead jobs failed permanently, they could be queued again or removed. Done jobs are removed with --done.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			switch {
+			case len(args) == 0 || len(args) == 1 && isJobState(args[0]):
+				state := ""
+				if len(args) == 1 {
+					state = args[0]
+				}
+				jobs, err := app.Storage.ReadAllStatus(state)
+				if err != nil {
+					return err
+				}
+				for _, status := range jobs {
+					fmt.Fprintf(out, "%s %-9s %s\n", status.ID, status.State, describeJob(status.Data))
+				}
+			case len(args) == 2 && args[0] == "show":
+				status, err := app.Storage.Status(args[1])
+				if err != nil {
+					return err
+				}
+				if status == nil {
+					return fmt.Errorf("Job %s is not found", args[1])
+				}
+				fmt.Fprintf(out, "Job: %s\n", describeJob(status.Data))
+				fmt.Fprintf(out, "State: %s\n", status.State)
+				fmt.Fprintf(out, "Attempts: %d\n", status.Attempts)
+				if status.LastError != "" {
+					red.Fprintf(out, "Last error: %s\n", status.LastError)
+				}
+				fmt.Fprintf(out, "Added: %s\n", status.AddedAt.Local().Format(time.RFC1123))
+				fmt.Fprintf(out, "Updated: %s\n", status.UpdatedAt.Local().Format(time.RFC1123))
+			case len(args) == 1 && args[0] == "dead":
+				dead, err := app.Storage.ReadAllDead()
+				if err != nil {
+					return err
+				}
+				for _, d := range dead {
+					fmt.Fprintf(out, "%s %s\n", d.ID, describeJob(d.Data))
+					red.Fprintf(out, "  failed at %s after %d attempts with status %d: %s\n",
+						d.FailedAt.Local().Format(time.RFC1123), d.Attempts, d.StatusCode, d.LastError)
+				}
+			case len(args) == 2 && args[0] == "retry":
+				return app.retryJob(args[1])
+			case len(args) == 1 && args[0] == "purge":
+				ok, err := app.Prompt.Confirm("Remove all dead jobs?", false)
+				if err != nil || !ok {
+					return err
+				}
+				n, err := app.Storage.PurgeDead()
+				if err != nil {
+					return err
+				}
+				green.Fprintf(out, "%d dead jobs removed\n", n)
+			case len(args) == 2 && args[0] == "purge" && args[1] == "--done":
+				n, err := app.Storage.PurgeDone(time.Now())
+				if err != nil {
+					return err
+				}
+				green.Fprintf(out, "%d done jobs removed\n", n)
+			default:
+				return errWrongArgs
+			}
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "ping",
+		Usage:   "ping",
+		Summary: "Check if the server is available.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			err := checkConnection(app.ctx(), app.Auth)
+			if err != nil {
+				return fmt.Errorf("Failed: server is not available: %s", err.Error())
+			}
+			green.Fprintln(out, "Ok: server is available")
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "config",
+		Usage:   "config show | config set <key> <value>",
+		Summary: "Show effective configuration or save the option to the configuration file.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			switch {
+			case len(args) == 1 && args[0] == "show":
+				app.Config.Show(out)
+			case len(args) >= 3 && args[0] == "set":
+				source, err := app.Config.Save(args[1], strings.Join(args[2:], " "))
+				if err != nil {
+					return err
+				}
+				if source != SourceFile {
+					blue.Fprintf(out, "Saved, but %s is overridden by %s\n", args[1], source)
+				} else {
+					green.Fprintf(out, "Saved %s\n", args[1])
+				}
+			default:
+				return errWrongArgs
+			}
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "profile",
+		Usage:   "profile [list] | profile use <name> | profile add <name> <api_host>",
+		Summary: "List server profiles, switch to the profile or add new one. Queued jobs stay with their profile.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			switch {
+			case len(args) == 0 || len(args) == 1 && args[0] == "list":
+				names, err := app.Config.Profiles()
+				if err != nil {
+					return err
+				}
+				for _, name := range names {
+					if name == app.Config.Profile {
+						green.Fprintf(out, "* %s\n", name)
+					} else {
+						fmt.Fprintf(out, "  %s\n", name)
+					}
+				}
+			case len(args) == 2 && args[0] == "use":
+				err := app.UseProfile(args[1])
+				if err != nil {
+					return err
+				}
+				err = app.Config.SaveProfile(args[1])
+				if err != nil {
+					return err
+				}
+				green.Fprintf(out, "Switched to profile %s (%s)\n", app.Config.Profile, app.Config.APIHost)
+			case len(args) == 3 && args[0] == "add":
+				err := app.Config.AddProfile(args[1], args[2])
+				if err != nil {
+					return err
+				}
+				green.Fprintf(out, "Profile %s added\n", args[1])
+			default:
+				return errWrongArgs
+			}
+
+			return nil
+		},
+	})
+	commands.Register(&Command{
+		Name:    "exit",
+		Aliases: []string{"quit"},
+		Usage:   "exit",
+		Summary: "Exit the console (or Ctrl+C).",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			return ErrExit
+		},
+	})
+}
+
+// isJobState checks if the argument is a name of the job state.
+func isJobState(arg string) bool {
+	switch arg {
+	case JobPending, JobInFlight, JobDone, JobFailed:
+		return true
+	}
+	return false
+}
+
+// describeJob decodes the saved job to show it in the list.
+func describeJob(data []byte) string {
+	job, err := DecodeJob(data)
+	if err != nil {
+		return "undecodable job"
+	}
+	return job.String()
+}
@@ -3,15 +3,35 @@ package main
 import (
 	"bytes"
 	"strings"
+	"time"
 )
 
 // Item represents base structure of elements like link, video and so on.
 type Item struct {
-	ID          string   `json:"id"`
-	userID      string   `json:"userId"`
-	Description string   `json:"description"`
-	Tags        []string `json:"tags"`
-	URL         string   `json:"url"`
+	ID          string    `json:"id"`
+	userID      string    `json:"userId"`
+	Description string    `json:"description"`
+	Tags        []string  `json:"tags"`
+	URL         string    `json:"url"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Deleted     bool      `json:"deleted,omitempty"`
+}
+
+// String formats item as a single line: id, url, tags and description.
+func (item *Item) String() string {
+	var b bytes.Buffer
+	if item.ID != "" {
+		b.WriteString("[" + item.ID + "] ")
+	}
+	b.WriteString(item.URL)
+	for _, tag := range item.Tags {
+		b.WriteString(" #" + tag)
+	}
+	if item.Description != "" {
+		b.WriteString(" " + item.Description)
+	}
+
+	return b.String()
 }
 
 // Link link type structure.
@@ -34,7 +54,6 @@ func ParseLink(args []string) (interface{}, error) {
 	tags := []string{}
 	var description bytes.Buffer
 	linkType := "link"
-	argsLen := len(args)
 	for i := 0; i < len(args); i++ {
 		switch {
 		case strings.HasPrefix(args[i], "http://"), strings.HasPrefix(args[i], "https://"):
@@ -42,7 +61,7 @@ func ParseLink(args []string) (interface{}, error) {
 		case strings.HasPrefix(args[i], "#"):
 			tags = append(tags, args[i][1:])
 		case strings.HasPrefix(args[i], "[") && strings.HasSuffix(args[i], "]"):
-			linkType = args[i][1 : argsLen-1]
+			linkType = args[i][1 : len(args[i])-1]
 		default:
 			if description.Len() > 0 {
 				description.WriteString(" ")
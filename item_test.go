@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLink(t *testing.T) {
+	for _, c := range []struct {
+		args     []string
+		video    bool
+		url      string
+		tags     []string
+		describe string
+	}{
+		{[]string{"[video]"}, true, "", []string{}, ""},
+		{[]string{"[video]", "https://youtu.be/1", "#go", "talk"}, true, "https://youtu.be/1", []string{"go"}, "talk"},
+		{[]string{"http://google.com", "[link]", "#search", "search", "engine"}, false, "http://google.com", []string{"search"}, "search engine"},
+	} {
+		item, err := ParseLink(c.args)
+		if err != nil {
+			t.Errorf("[TestParseLink] %v failed: %s", c.args, err.Error())
+			continue
+		}
+		var parsed Item
+		switch i := item.(type) {
+		case *Video:
+			parsed = i.Item
+		case *Link:
+			parsed = i.Item
+		}
+		if _, ok := item.(*Video); ok != c.video {
+			t.Errorf("[TestParseLink] %v: expected video %t, given %T", c.args, c.video, item)
+		}
+		if parsed.URL != c.url || !reflect.DeepEqual(parsed.Tags, c.tags) || parsed.Description != c.describe {
+			t.Errorf("[TestParseLink] %v: unexpected item %v", c.args, item)
+		}
+	}
+
+	// Type given alone is not a change of the link
+	link := &Link{}
+	link.URL = "http://google.com"
+	if err := mergeLink(link, []string{"[video]"}); err == nil {
+		t.Errorf("[TestParseLink] Link should not be changed to video")
+	}
+}
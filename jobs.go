@@ -1,11 +1,198 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
 
-// Job test job, which should implements required method GetID() string
+// Job types. Type is saved with the job, so the type names should never change.
+const (
+	JobLinkCreate = "link.create"
+	JobLinkUpdate = "link.update"
+	JobLinkDelete = "link.delete"
+	JobUserCreate = "user.create"
+	JobTagChange  = "tag.change"
+)
+
+// Job is a change queued to send to the server, which should implements required method GetID() string.
+// Type defines which of the payload fields is set.
 type Job struct {
-	ID   string
-	Link *Link
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Link is the link to create or the full link to replace on update.
+	Link *Link `json:"link,omitempty"`
+	// LinkID is the link to delete.
+	LinkID string `json:"linkId,omitempty"`
+	// User is the user to create, the password is sealed in SealedPassword and never saved in plaintext.
+	// Jobs saved by previous versions keep the password in User.
+	User *UserCreateRequest `json:"user,omitempty"`
+	// SealedPassword is the password of the new user encrypted with the password of the profile credentials.
+	SealedPassword []byte     `json:"sealedPassword,omitempty"`
+	Tags           *TagChange `json:"tags,omitempty"`
+}
+
+// DecodeJob decodes saved job. Jobs saved before types were added create links or change tags.
+func DecodeJob(data []byte) (Job, error) {
+	job := Job{}
+	err := json.Unmarshal(data, &job)
+	if err != nil {
+		return job, fmt.Errorf("Decoding job failed: %s", err.Error())
+	}
+	if job.Type == "" {
+		job.Type = JobLinkCreate
+		if job.Tags != nil {
+			job.Type = JobTagChange
+		}
+	}
+
+	return job, nil
+}
+
+// withoutPassword returns the saved job with the password of the new user removed.
+func withoutPassword(data []byte) []byte {
+	job, err := DecodeJob(data)
+	if err != nil || job.User == nil || (job.User.Password == "" && len(job.SealedPassword) == 0) {
+		return data
+	}
+	job.User = &UserCreateRequest{Username: job.User.Username}
+	job.SealedPassword = nil
+	b, _ := json.Marshal(job)
+
+	return b
+}
+
+// String describes the job for console messages, the validation error if the job has no payload.
+func (job Job) String() string {
+	if err := job.validate(); err != nil {
+		return err.Error()
+	}
+	switch job.Type {
+	case JobLinkCreate:
+		return fmt.Sprintf("AddLink for %s", job.Link)
+	case JobLinkUpdate:
+		return fmt.Sprintf("UpdateLink %s", job.Link)
+	case JobLinkDelete:
+		return "DeleteLink " + job.LinkID
+	case JobUserCreate:
+		return "AddUser " + job.User.Username
+	case JobTagChange:
+		return "ChangeTags " + job.Tags.String()
+	}
+	return fmt.Sprintf("Unknown job %s of type %s", job.ID, job.Type)
+}
+
+// InvalidJobError is returned if the job could not be sent because of unknown type or missing payload.
+type InvalidJobError struct {
+	msg string
+}
+
+func (err *InvalidJobError) Error() string {
+	return err.msg
+}
+
+// validate checks the payload of the job type is set.
+func (job Job) validate() error {
+	missing := false
+	switch job.Type {
+	case JobLinkCreate, JobLinkUpdate:
+		missing = job.Link == nil
+	case JobLinkDelete:
+		missing = job.LinkID == ""
+	case JobUserCreate:
+		missing = job.User == nil || (job.User.Password == "" && len(job.SealedPassword) == 0)
+	case JobTagChange:
+		missing = job.Tags == nil
+	default:
+		return &InvalidJobError{fmt.Sprintf("Unknown job type %s of job #%s", job.Type, job.ID)}
+	}
+	if missing {
+		return &InvalidJobError{fmt.Sprintf("Job #%s of type %s has no payload", job.ID, job.Type)}
+	}
+
+	return nil
+}
+
+// newUser returns the user to create with the password opened by the password of the profile credentials.
+// The job could not be sent if the password could not be opened, e.g. the credentials are changed.
+func (job Job) newUser(auth *Auth) (*UserCreateRequest, error) {
+	if len(job.SealedPassword) == 0 {
+		return job.User, nil
+	}
+	password, err := openSecret(auth.password(), job.SealedPassword)
+	if err != nil {
+		return nil, &InvalidJobError{fmt.Sprintf("Password of job #%s could not be opened: %s", job.ID, err.Error())}
+	}
+
+	return &UserCreateRequest{Username: job.User.Username, Password: password}, nil
+}
+
+// dispatch sends the job to the API call of its type.
+func dispatch(ctx context.Context, auth *Auth, job Job) JobResult {
+	jobResult := JobResult{job: job}
+	err := job.validate()
+	if err != nil {
+		jobResult.lastError = err
+		return jobResult
+	}
+	// Job ID is the idempotency key, so the job sent again after a crash is not applied twice
+	switch job.Type {
+	case JobLinkCreate:
+		jobResult.link, jobResult.lastError = addLink(ctx, auth, job.ID, job.Link)
+	case JobLinkUpdate:
+		jobResult.link, jobResult.lastError = updateLink(ctx, auth, job.ID, job.Link)
+	case JobLinkDelete:
+		jobResult.lastError = deleteLink(ctx, auth, job.ID, job.LinkID)
+	case JobUserCreate:
+		var newUser *UserCreateRequest
+		newUser, jobResult.lastError = job.newUser(auth)
+		if jobResult.lastError == nil {
+			jobResult.lastError = createUser(ctx, auth, job.ID, newUser)
+		}
+	case JobTagChange:
+		jobResult.lastError = changeTags(ctx, auth, job.ID, job.Tags)
+	}
+	if alreadyDone(job, jobResult.lastError) {
+		jobResult.lastError = nil
+	}
+
+	return jobResult
+}
+
+// rateLimitedTries is the number of times sendJob dispatches the job rejected as one of too many requests.
+const rateLimitedTries = 3
+
+// sendJob dispatches the job again while the server rejects it as one of too many requests, up to
+// rateLimitedTries times. Meanwhile the limiter of the API pauses requests for the time asked by the server.
+// Then the job fails and the session sends it again later. The job fails at once with PausedError if the pause is
+// longer than the limiter waits, the session sends it again after the pause.
+func sendJob(ctx context.Context, auth *Auth, job Job) JobResult {
+	jobResult := dispatch(ctx, auth, job)
+	for tries := 1; tries < rateLimitedTries && IsRateLimited(jobResult.lastError) && auth.api().Limiter != nil && ctx.Err() == nil; tries++ {
+		jobResult = dispatch(ctx, auth, job)
+	}
+
+	return jobResult
+}
+
+// alreadyDone checks if the error means the change of the job is already made, e.g. by the previous attempt,
+// which succeeded, but the client stopped before the job was marked as done. The link to delete is not found then.
+// Conflict is not the proof of the previous attempt, the server confirms it by returning the link created with
+// the same idempotency key (see API.LinkAdd), other conflicts fail the job. Removed tags are checked one by one
+// by changeTags.
+func alreadyDone(job Job, err error) bool {
+	e, ok := err.(*APIError)
+	if !ok {
+		return false
+	}
+	if job.Type == JobLinkDelete {
+		return e.StatusCode() == http.StatusNotFound || e.StatusCode() == http.StatusGone
+	}
+
+	return false
 }
 
 // JobResult test job result, which should implements required methods
@@ -14,6 +201,9 @@ type Job struct {
 type JobResult struct {
 	lastError error
 	job       Job
+	link      *Link
+	// attempts is the number of times the job was sent in the session.
+	attempts int
 }
 
 // GetID implement Job interface
@@ -26,27 +216,55 @@ func (jobResult JobResult) GetJobID() string {
 	return jobResult.job.GetID()
 }
 
+// Link returns the link created or updated by the job, nil if the job is not done.
+func (jobResult JobResult) Link() *Link {
+	return jobResult.link
+}
+
 // IsDone implement JobResult interface
 func (jobResult JobResult) IsDone() bool {
 	return jobResult.lastError == nil
 }
 
-// IsCorrupted implement JobResult interface
-func (jobResult JobResult) IsCorrupted() bool {
-	return false
+// RetryAfter returns the time left until the end of the pause asked by the server, if the job was not sent
+// because of the pause, 0 otherwise.
+func (jobResult JobResult) RetryAfter() time.Duration {
+	var paused *PausedError
+	if errors.As(jobResult.lastError, &paused) {
+		return paused.Delay
+	}
+
+	return 0
 }
 
-// ConnectionFailed indicates if connection failed or service unavailable. In both cases need to retry the job.
-func (jobResult JobResult) ConnectionFailed() bool {
-	res := false
+// IsCorrupted implement JobResult interface. The job is corrupted if it's invalid or the server rejected it
+// as a wrong request, sending it again fails the same way. Authorisation, timeout and rate limit errors
+// could pass later.
+func (jobResult JobResult) IsCorrupted() bool {
 	switch t := jobResult.lastError.(type) {
-	case *APIConnectionFailed:
-		res = true
+	case *InvalidJobError:
+		return true
 	case *APIError:
-		if t.code >= http.StatusInternalServerError {
-			res = true
-		}
+		return !t.IsRetryable() && !t.IsUnauthorized() && t.StatusCode() >= http.StatusBadRequest
+	}
+
+	return false
+}
+
+// StatusCode returns HTTP status of the failed request, 0 if the job failed before getting response.
+func (jobResult JobResult) StatusCode() int {
+	if t, ok := jobResult.lastError.(*APIError); ok {
+		return t.StatusCode()
 	}
+	return 0
+}
 
-	return res
+// Attempts returns the number of times the job was sent in the session.
+func (jobResult JobResult) Attempts() int {
+	return jobResult.attempts
+}
+
+// ConnectionFailed indicates if connection failed or service unavailable. In both cases need to retry the job.
+func (jobResult JobResult) ConnectionFailed() bool {
+	return IsUnavailable(jobResult.lastError)
 }
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJob(t *testing.T) {
+	link := &Link{}
+	link.URL = "http://google.com"
+	for _, c := range []struct {
+		data string
+		job  Job
+	}{
+		// Saved before job types were added
+		{`{"ID":"1","Link":{"url":"http://google.com"}}`, Job{ID: "1", Type: JobLinkCreate, Link: link}},
+		{`{"ID":"2","Link":null,"Tags":{"from":["a"],"to":"b"}}`, Job{ID: "2", Type: JobTagChange, Tags: &TagChange{From: []string{"a"}, To: "b"}}},
+		{`{"id":"3","type":"link.delete","linkId":"5a1b2c"}`, Job{ID: "3", Type: JobLinkDelete, LinkID: "5a1b2c"}},
+	} {
+		job, err := DecodeJob([]byte(c.data))
+		if err != nil || !reflect.DeepEqual(job, c.job) {
+			t.Errorf("[TestDecodeJob] %s: expected %v, given %v %v", c.data, c.job, job, err)
+		}
+	}
+
+	// Encoded job is decoded back
+	job := Job{ID: "4", Type: JobUserCreate, User: &UserCreateRequest{Username: "user", Password: "pass"}}
+	b, _ := json.Marshal(job)
+	decoded, err := DecodeJob(b)
+	if err != nil || !reflect.DeepEqual(decoded, job) {
+		t.Errorf("[TestDecodeJob] Expected %v, given %v %v", job, decoded, err)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	requests := []string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/user/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-AUTH-TOKEN", "token")
+	})
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+strings.TrimSpace(string(b)))
+		if strings.HasPrefix(r.URL.Path, "/api/item/link") && r.Method != "DELETE" {
+			w.Write(b)
+		}
+	})
+	auth, _ := newTestAuthServer(t, mux)
+
+	link := &Link{}
+	link.ID = "5a1b2c"
+	link.URL = "http://google.com"
+	for _, c := range []struct {
+		job     Job
+		request string
+	}{
+		{Job{ID: "1", Type: JobLinkCreate, Link: link}, `PUT /api/item/link {"id":"5a1b2c"`},
+		{Job{ID: "2", Type: JobLinkUpdate, Link: link}, `POST /api/item/link/5a1b2c {"id":"5a1b2c"`},
+		{Job{ID: "3", Type: JobLinkDelete, LinkID: "5a1b2c"}, `DELETE /api/item/link/5a1b2c`},
+		{Job{ID: "4", Type: JobUserCreate, User: &UserCreateRequest{Username: "user", Password: "pass"}}, `PUT /api/user {"username":"user","password":"pass"}`},
+		{Job{ID: "5", Type: JobTagChange, Tags: &TagChange{From: []string{"a"}, To: "b"}}, `POST /api/tag/rename {"from":["a"],"to":"b"}`},
+	} {
+		requests = requests[:0]
+		jobResult := dispatch(context.Background(), auth, c.job)
+		if !jobResult.IsDone() {
+			t.Errorf("[TestDispatch] %s failed: %v", c.job, jobResult.lastError)
+		}
+		if len(requests) != 1 || !strings.HasPrefix(requests[0], c.request) {
+			t.Errorf("[TestDispatch] %s: expected request %s, given %v", c.job, c.request, requests)
+		}
+		if (c.job.Type == JobLinkCreate || c.job.Type == JobLinkUpdate) && jobResult.Link() == nil {
+			t.Errorf("[TestDispatch] %s: link is expected in the result", c.job)
+		}
+	}
+
+	jobResult := dispatch(context.Background(), auth, Job{ID: "6", Type: "unknown"})
+	if jobResult.IsDone() {
+		t.Errorf("[TestDispatch] Unknown job type should fail")
+	}
+}
+
+func TestDispatchSealedPassword(t *testing.T) {
+	requests := []string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/user/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-AUTH-TOKEN", "token")
+	})
+	mux.HandleFunc("/api/user", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, strings.TrimSpace(string(b)))
+	})
+	auth, _ := newTestAuthServer(t, mux)
+
+	sealed, err := sealSecret(auth.password(), "secret")
+	if err != nil {
+		t.Fatalf("[TestDispatchSealedPassword] Sealing password failed: %s", err.Error())
+	}
+	job := Job{ID: "1", Type: JobUserCreate, User: &UserCreateRequest{Username: "new"}, SealedPassword: sealed}
+	data, _ := json.Marshal(job)
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("[TestDispatchSealedPassword] Password is saved in plaintext: %s", data)
+	}
+	if stripped := withoutPassword(data); strings.Contains(string(stripped), "sealedPassword") {
+		t.Errorf("[TestDispatchSealedPassword] Sealed password is expected to be removed: %s", stripped)
+	}
+
+	jobResult := dispatch(context.Background(), auth, job)
+	expected := `{"username":"new","password":"secret"}`
+	if !jobResult.IsDone() || len(requests) != 1 || requests[0] != expected {
+		t.Errorf("[TestDispatchSealedPassword] Expected request %s, given %v %v", expected, requests, jobResult.lastError)
+	}
+
+	// Password sealed with other credentials could not be sent
+	auth.SetUserCredentials(&UserCredentials{Username: "user", Password: "other"})
+	jobResult = dispatch(context.Background(), auth, job)
+	if jobResult.IsDone() || !jobResult.IsCorrupted() || len(requests) != 1 {
+		t.Errorf("[TestDispatchSealedPassword] Job is expected to be corrupted, given %v %v", requests, jobResult.lastError)
+	}
+}
+
+func TestJobResultIsCorrupted(t *testing.T) {
+	for _, c := range []struct {
+		err              error
+		corrupted        bool
+		connectionFailed bool
+	}{
+		{&InvalidJobError{"unknown type"}, true, false},
+		{&APIError{code: http.StatusBadRequest}, true, false},
+		{&APIError{code: http.StatusUnprocessableEntity}, true, false},
+		{&APIError{code: http.StatusUnauthorized}, false, false},
+		{&APIError{code: http.StatusTooManyRequests}, false, false},
+		{&APIError{code: http.StatusServiceUnavailable}, false, true},
+		{&APIConnectionFailed{"connection refused"}, false, true},
+	} {
+		jobResult := JobResult{lastError: c.err}
+		if jobResult.IsCorrupted() != c.corrupted || jobResult.ConnectionFailed() != c.connectionFailed {
+			t.Errorf("[TestJobResultIsCorrupted] %T %v: expected corrupted %t, connection failed %t", c.err, c.err, c.corrupted, c.connectionFailed)
+		}
+	}
+
+	jobResult := dispatch(context.Background(), nil, Job{ID: "1", Type: JobLinkCreate})
+	if !jobResult.IsCorrupted() {
+		t.Errorf("[TestJobResultIsCorrupted] Job without payload should be corrupted")
+	}
+}
+
+// newIdempotentServer creates Auth with the fake server, which creates and removes links once per idempotency key.
+func newIdempotentServer(t *testing.T) (*Auth, map[string]*Link) {
+	links := map[string]*Link{}
+	created := map[string]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/user/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-AUTH-TOKEN", "token")
+	})
+	mux.HandleFunc("/api/item/link", func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if id, ok := created[key]; ok {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(links[id])
+			return
+		}
+		link := &Link{}
+		json.NewDecoder(r.Body).Decode(link)
+		link.ID = fmt.Sprintf("id%d", len(created)+1)
+		links[link.ID] = link
+		created[key] = link.ID
+		json.NewEncoder(w).Encode(link)
+	})
+	mux.HandleFunc("/api/item/link/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/item/link/")
+		if _, ok := links[id]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(links, id)
+	})
+	auth, _ := newTestAuthServer(t, mux)
+
+	return auth, links
+}
+
+// replaySavedJobs reopens the storage as on the next start and sends all saved jobs.
+func replaySavedJobs(t *testing.T, auth *Auth) []JobResult {
+	storage, err := NewStorage(TestDBName)
+	if err != nil {
+		t.Fatalf("Unable to open storage: %s", err.Error())
+	}
+	saved, err := storage.ReadAll()
+	if err != nil {
+		t.Fatalf("Unable to read saved jobs: %s", err.Error())
+	}
+	results := []JobResult{}
+	for _, data := range saved {
+		job, err := DecodeJob(data)
+		if err != nil {
+			t.Fatalf("Unable to decode saved job: %s", err.Error())
+		}
+		storage.Attempt(job.ID)
+		jobResult := dispatch(context.Background(), auth, job)
+		if jobResult.IsDone() {
+			storage.SetState(job.ID, JobDone, "")
+		}
+		results = append(results, jobResult)
+	}
+
+	return results
+}
+
+func TestDispatchReplayAfterCrash(t *testing.T) {
+	os.Remove(TestDBName)
+	auth, links := newIdempotentServer(t)
+
+	storage, err := NewStorage(TestDBName)
+	if err != nil {
+		t.Fatalf("[TestDispatchReplayAfterCrash] Unable to create new storage: %s", err.Error())
+	}
+	link := &Link{}
+	link.URL = "http://google.com"
+	job := Job{ID: "job1", Type: JobLinkCreate, Link: link}
+	b, _ := json.Marshal(job)
+	storage.Put(job.ID, b)
+
+	// The link is created, but the client crashes before the job is marked as done
+	jobResult := dispatch(context.Background(), auth, job)
+	if !jobResult.IsDone() || jobResult.Link() == nil {
+		t.Fatalf("[TestDispatchReplayAfterCrash] Job failed: %v", jobResult.lastError)
+	}
+	createdID := jobResult.Link().ID
+
+	results := replaySavedJobs(t, auth)
+	if len(results) != 1 || !results[0].IsDone() {
+		t.Fatalf("[TestDispatchReplayAfterCrash] Replayed job should be done, given %v", results)
+	}
+	if results[0].Link() == nil || results[0].Link().ID != createdID {
+		t.Errorf("[TestDispatchReplayAfterCrash] The link created before is expected, given %v", results[0].Link())
+	}
+	if len(links) != 1 {
+		t.Errorf("[TestDispatchReplayAfterCrash] Link should be created once, given %d links", len(links))
+	}
+	if results = replaySavedJobs(t, auth); len(results) != 0 {
+		t.Errorf("[TestDispatchReplayAfterCrash] Done job should not be sent again, given %v", results)
+	}
+
+	// The link is removed, but the client crashes before the job is marked as done
+	job = Job{ID: "job2", Type: JobLinkDelete, LinkID: createdID}
+	b, _ = json.Marshal(job)
+	storage.Put(job.ID, b)
+	jobResult = dispatch(context.Background(), auth, job)
+	if !jobResult.IsDone() || len(links) != 0 {
+		t.Fatalf("[TestDispatchReplayAfterCrash] Job failed: %v", jobResult.lastError)
+	}
+	results = replaySavedJobs(t, auth)
+	if len(results) != 1 || !results[0].IsDone() {
+		t.Errorf("[TestDispatchReplayAfterCrash] Replayed removal should be done, given %v", results)
+	}
+}
+
+func TestDispatchConflictsAndMissingTags(t *testing.T) {
+	keys := []string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/user/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-AUTH-TOKEN", "token")
+	})
+	mux.HandleFunc("/api/item/link", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message":"link already exists"}`))
+	})
+	mux.HandleFunc("/api/user", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message":"username is taken"}`))
+	})
+	mux.HandleFunc("/api/tag/", func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+		switch strings.TrimPrefix(r.URL.Path, "/api/tag/") {
+		case "missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	auth, _ := newTestAuthServer(t, mux)
+
+	// Conflict without the created resource is not a proof of the previous attempt
+	link := &Link{}
+	link.URL = "http://google.com"
+	for _, job := range []Job{
+		{ID: "1", Type: JobLinkCreate, Link: link},
+		{ID: "2", Type: JobUserCreate, User: &UserCreateRequest{Username: "user", Password: "pass"}},
+	} {
+		jobResult := dispatch(context.Background(), auth, job)
+		if jobResult.IsDone() || !jobResult.IsCorrupted() || jobResult.StatusCode() != http.StatusConflict {
+			t.Errorf("[TestDispatchConflictsAndMissingTags] %s should fail with conflict, given %v", job, jobResult.lastError)
+		}
+	}
+
+	// Each tag is removed with own key, missing tag is removed already
+	job := Job{ID: "3", Type: JobTagChange, Tags: &TagChange{From: []string{"a", "missing", "b"}}}
+	jobResult := dispatch(context.Background(), auth, job)
+	if !jobResult.IsDone() {
+		t.Errorf("[TestDispatchConflictsAndMissingTags] Removal of missing tag should be done, given %v", jobResult.lastError)
+	}
+	if !reflect.DeepEqual(keys, []string{"3/a", "3/missing", "3/b"}) {
+		t.Errorf("[TestDispatchConflictsAndMissingTags] Unexpected idempotency keys %v", keys)
+	}
+
+	keys = keys[:0]
+	job = Job{ID: "4", Type: JobTagChange, Tags: &TagChange{From: []string{"broken", "a"}}}
+	jobResult = dispatch(context.Background(), auth, job)
+	if jobResult.IsDone() || len(keys) != 1 {
+		t.Errorf("[TestDispatchConflictsAndMissingTags] Removal should stop on the failed tag, given %v %v", jobResult.lastError, keys)
+	}
+}
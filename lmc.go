@@ -1,208 +1,137 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/fatih/color"
-	"github.com/satori/go.uuid"
 	s "github.com/viktor-br/jobs-scheduler"
 	"log"
 	"os"
 	"os/signal"
 	u "os/user"
-	"path/filepath"
 	"strings"
 	"syscall"
-	"time"
-	"unicode"
+)
+
+var (
+	green = color.New(color.FgGreen)
+	red   = color.New(color.FgRed)
+	blue  = color.New(color.FgBlue)
 )
 
 func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run starts the REPL if no arguments given, otherwise runs arguments as a single command and returns exit code.
+func run(args []string) int {
 	usr, err := u.Current()
 	if err != nil {
 		fmt.Printf("Cannot get current OS user details: %s\n", err.Error())
-		return
+		return 1
 	}
-	dir := usr.HomeDir
-	config := &Config{
-		Dir:                 dir + string(filepath.Separator) + ".lmc",
-		AuthTokenFilename:   "auth.token",
-		CredentialsFilename: "credentials",
-		APIHost:             "http://localhost:8080/api/",
-		LogFilename:         "links-manager-client.log",
-		StorageName:         "lmc.db",
+	config, args, err := LoadConfig(usr.HomeDir, args, os.Stderr)
+	if err == flag.ErrHelp {
+		return 0
 	}
-	userCredentials, err := setup(config)
-	if err != nil {
-		fmt.Println(err.Error())
-		return
-	}
-	auth := Auth{}
-	auth.Config = config
-	auth.UserCredentials = userCredentials
-
-	storage, err := NewStorage(config.StoragePath())
 	if err != nil {
-		fmt.Printf("Storage opening failed %s", err.Error())
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
 	}
-
-	// colors
-	green := color.New(color.FgGreen)
-	red := color.New(color.FgRed)
-	blue := color.New(color.FgBlue)
-
 	var buf bytes.Buffer
 	// Init logger with output to file
+	err = os.MkdirAll(config.Dir, 0755)
+	if err != nil {
+		fmt.Printf("Configuration folder %s could not be created: %s\n", config.Dir, err.Error())
+		return 1
+	}
 	f, err := os.OpenFile(config.LogPath(), os.O_CREATE|os.O_RDWR, 0666)
 	if err != nil {
 		fmt.Printf("Failed to open file: %v", err)
-		return
+		return 1
 	}
 	err = f.Truncate(0)
 	if err != nil {
 		fmt.Printf("Failed to clear log file: %v", err)
-		return
+		return 1
 	}
 	defer f.Close()
 
 	logger := log.New(&buf, "", log.Ldate|log.Ltime|log.LUTC)
 	logger.SetOutput(f)
 
-	jobs := make(chan Job, 10)
-	noConnection := make(chan bool)
+	app := &App{Logger: logger, Prompt: NewPrompt(os.Stdin, os.Stdout)}
+	err = app.Start(config)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
 
-	// Create scheduler with simple processor, which sleeps 3 seconds to emulate it's doing something.
-	// TODO check if it worth it to use closure to pass authentication
-	scheduler := s.NewJobsScheduler(func(job s.Job) s.JobResult {
-		jobResult := JobResult{}
-		jobResult.job = job.(Job)
-		switch job.(type) {
-		case Job:
-			_, err := addLink(&auth, job.(Job).Link)
-			if err != nil {
-				jobResult.lastError = err
-			}
-		default:
-			jobResult.lastError = fmt.Errorf("Unknow job type #%s", job.GetID())
-		}
-		return jobResult
-	})
-	// Set up options
-	scheduler.Option(s.MaxTries(3), s.ProcessorsNum(2))
-	scheduler.AddLogger(func(msg string) {
-		logger.Println(msg)
-	})
-	// Add function which process results flow
-	scheduler.AddResultOutput(func(res s.JobResult) {
-		switch res.(type) {
-		case JobResult:
-			jobResult := res.(JobResult)
-			logger.Printf("JobResult received: %v", jobResult)
-			if !jobResult.IsDone() {
-				logger.Printf("job #%s is not done: %s\n", res.GetJobID(), res.(JobResult).lastError.Error())
-				// Send signal, connection failed, so we need to stop send requests and wait reestablishing connection.
-				if jobResult.ConnectionFailed() {
-					noConnection <- true
-				}
-			} else {
-				storage.Remove(res.GetJobID())
-				logger.Printf("job #%s successed\n", res.GetJobID())
-			}
-		default:
-			logger.Println("unknown result type")
-		}
-	})
-	scheduler.Run()
+	if len(args) > 0 {
+		app.waitJobs = true
+		err = app.Run(strings.Join(args, " "))
+		app.Stop()
+
+		return exitCode(err)
+	}
 
 	// Read previously saved uncompleted jobs from file
-	readAllSavedJobsAndSchedule(scheduler, storage)
+	readAllSavedJobsAndSchedule(app.Scheduler, app.Storage)
 
-	reader := bufio.NewReader(os.Stdin)
 	// Buffer = 1 b/c no need to block the goroutine.
 	signalsDone := make(chan bool, 1)
 	// Wait for Ctrl+C close signal
-	go func(scheduler *s.JobsScheduler, signalsDone chan bool) {
+	go func(signalsDone chan bool) {
 		signals := make(chan os.Signal, 1)
 		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 		<-signals
 		fmt.Println()
-		scheduler.Shutdown()
 		signalsDone <- true
-	}(scheduler, signalsDone)
-
-	// Run separate goroutine, which accepts a job and forward it either to scheduler or to local storage.
-	go schedule(&auth, scheduler, noConnection, jobs, storage)
+	}(signalsDone)
 
 	// Command line goroutine, read and run command
-	go func(scheduler *s.JobsScheduler) {
-	Exit:
+	go func() {
 		for {
-			blue.Print("cmd> ")
-			cmd, _ := reader.ReadString('\n')
-			cmd = strings.TrimSpace(cmd)
-			args := strings.FieldsFunc(cmd, func(c rune) bool {
-				return unicode.IsSpace(c)
-			})
-			if len(args) == 0 {
-				continue
+			blue.Printf("%s> ", app.prompt())
+			cmd, err := app.Prompt.ReadLine()
+			if err == nil {
+				err = app.Run(cmd)
+			} else {
+				// Input is closed
+				fmt.Println()
+				err = ErrExit
 			}
-			// Identify command by first argument
-			switch strings.TrimSpace(args[0]) {
-			case "ua":
-				newUser, err := addUser(&auth)
-				if err == nil {
-					blue.Printf("User created %v\n", newUser)
-				} else {
-					red.Printf("%v\n", err)
-				}
-			case "auth":
-				_, err := auth.Authenticate()
-				if err != nil {
-					red.Printf("%v\n", err)
-				} else {
-					green.Println("Authorised OK")
-				}
-			case "credentials":
-				_, _, err = readAndSaveUserCredentials(config.CredentialsPath())
-				if err == nil {
-					blue.Println("Credentials saved")
-				} else {
-					red.Printf("%v\n", err)
-				}
-			case "exit":
-				scheduler.Shutdown()
+			if err == ErrExit {
 				signalsDone <- true
-				break Exit
-			case "ping":
-				if checkConnection(&auth) {
-					green.Println("Ok: server is available")
-				} else {
-					red.Println("Failed: server is not available")
-				}
-			default:
-				// If command starts with url, user wants to add link
-				if strings.HasPrefix(args[0], "http://") || strings.HasPrefix(args[0], "https://") {
-					link, err := ParseLink(args)
-					if err != nil {
-						red.Printf("%v\n", err)
-					} else {
-						switch link.(type) {
-						case *Link:
-							jobs <- Job{ID: uuid.NewV4().String(), Link: link.(*Link)}
-						default:
-							red.Println("Unknown item type: %v", link)
-						}
-					}
-				}
+				break
+			}
+			if err != nil {
+				red.Printf("%v\n", err)
 			}
 		}
-	}(scheduler)
+	}()
 
 	<-signalsDone
 
-	scheduler.Wait()
+	app.Stop()
+
+	return 0
+}
+
+// exitCode converts command error to the process exit code: 0 - success, 1 - command failed, 2 - wrong usage.
+func exitCode(err error) int {
+	if err == nil || err == ErrExit {
+		return 0
+	}
+	red.Fprintln(os.Stderr, err.Error())
+	if _, ok := err.(*UsageError); ok {
+		return 2
+	}
+
+	return 1
 }
 
 func readAllSavedJobsAndSchedule(scheduler *s.JobsScheduler, storage Storage) {
@@ -212,8 +141,11 @@ func readAllSavedJobsAndSchedule(scheduler *s.JobsScheduler, storage Storage) {
 		fmt.Println("Cannot read uncompleted jobs from storage %s", err.Error())
 	}
 	for i := 0; i < len(savedJobsData); i++ {
-		savedJob := Job{}
-		json.Unmarshal(savedJobsData[i], &savedJob)
+		savedJob, err := DecodeJob(savedJobsData[i])
+		if err != nil {
+			fmt.Println(err.Error())
+			continue
+		}
 		savedJobs = append(savedJobs, savedJob)
 	}
 
@@ -226,57 +158,91 @@ func readAllSavedJobsAndSchedule(scheduler *s.JobsScheduler, storage Storage) {
 	}
 }
 
-func schedule(auth *Auth, scheduler *s.JobsScheduler, noConnection chan bool, jobs chan Job, storage Storage) {
-	successChan := make(chan bool)
+// schedule accepts jobs of the session and forwards them either to the scheduler or to the local storage,
+// until the session is stopped.
+func schedule(app *App, sess *session) {
+	// Buffer = 1 b/c the session could be stopped before the server is available.
+	successChan := make(chan bool, 1)
 	connectionFailed := false
-	green := color.New(color.FgGreen)
-	red := color.New(color.FgRed)
+	defer close(sess.stopped)
 
 	for {
 		select {
-		case <-noConnection:
+		case <-sess.stop:
+			// Save jobs accepted but not scheduled yet, they are sent on the next start of the session.
+			for {
+				select {
+				case job := <-sess.jobs:
+					saveJob(app, sess, job)
+					app.notify(JobResult{job: job, lastError: &APIConnectionFailed{"session is stopped"}})
+				default:
+					return
+				}
+			}
+		case <-sess.noConnection:
 			// Ignore a channel failure while processing a previous one still in progress.
 			if !connectionFailed {
 				connectionFailed = true
 				// Run goroutine to periodically check if the server is available.
-				go waitForServerAvailable(auth, successChan)
+				go waitForServerAvailable(sess.ctx, sess.auth, sess.backoff, successChan)
 			}
 		case <-successChan:
 			connectionFailed = false
-			readAllSavedJobsAndSchedule(scheduler, storage)
-		case job := <-jobs:
-			// Save job to storage, in case connection failed, we could restart jobs
-			b, err := json.Marshal(job)
-			if err != nil {
-				// TODO write to log file
-			} else {
-				storage.Put(job.ID, b)
+			readAllSavedJobsAndSchedule(sess.scheduler, sess.storage)
+			// Refresh local copy of items, which could be changed while the server was unavailable.
+			go func() {
+				_, err := syncItems(sess.ctx, sess.auth, sess.cache)
+				if err != nil {
+					red.Printf("Items synchronisation failed: %v\n", err)
+				}
+			}()
+		case job := <-sess.retries:
+			// The job failed before is already saved, it's sent on reconnection if the server is not available.
+			if !connectionFailed {
+				err := sess.scheduler.Add(job)
+				if err != nil {
+					red.Printf("%s is not scheduled again: %v\n", job, err)
+				}
 			}
+		case job := <-sess.jobs:
+			// Save job to storage, in case connection failed, we could restart jobs
+			saveJob(app, sess, job)
 			if !connectionFailed {
 				// Send the job to the scheduler
-				err := scheduler.Add(job)
+				err := sess.scheduler.Add(job)
 				if err == nil {
-					green.Printf("AddLink for %s scheduled\n", job.Link)
+					green.Printf("%s scheduled\n", job)
 				} else {
 					red.Printf("Parsed link: %v\n", err)
+					app.notify(JobResult{job: job, lastError: err})
 				}
+			} else {
+				app.notify(JobResult{job: job, lastError: &APIConnectionFailed{"server is not available"}})
 			}
 		}
 	}
 }
 
-// waitForServerAvailable requests a server endpoint and exit in case the server is available.
-func waitForServerAvailable(auth *Auth, success chan bool) {
-	timeout := 15 * time.Second
-	defer func() {
+// saveJob puts job to the session storage.
+func saveJob(app *App, sess *session, job Job) {
+	b, err := json.Marshal(job)
+	if err != nil {
+		app.Logger.Printf("job #%s encoding failed: %s\n", job.ID, err.Error())
+		return
+	}
+	err = sess.storage.Put(job.ID, b)
+	if err != nil {
+		app.Logger.Printf("job #%s saving failed: %s\n", job.ID, err.Error())
+	}
+}
+
+// waitForServerAvailable requests a server endpoint with backoff delays and exit in case the server is available.
+// Nothing is sent to success if the context is done before.
+func waitForServerAvailable(ctx context.Context, auth *Auth, backoff *Backoff, success chan bool) {
+	err := backoff.Retry(ctx, func() bool {
+		return checkConnection(ctx, auth) == nil
+	})
+	if err == nil {
 		success <- true
-	}()
-	for {
-		if checkConnection(auth) {
-			break
-		} else {
-			time.Sleep(timeout)
-			timeout = timeout * 2
-		}
 	}
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Prompt owns the console input, all reads from the user go through it, so the REPL and commands
+// never read stdin concurrently.
+type Prompt struct {
+	mu     sync.Mutex
+	reader *bufio.Reader
+	out    io.Writer
+	// fd is the terminal file descriptor to read passwords without echo, -1 if input is not a terminal.
+	fd int
+}
+
+// NewPrompt creates prompt reading from in and writing questions to out. Passwords are hidden
+// if in is a terminal.
+func NewPrompt(in io.Reader, out io.Writer) *Prompt {
+	fd := -1
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		fd = int(f.Fd())
+	}
+	return &Prompt{reader: bufio.NewReader(in), out: out, fd: fd}
+}
+
+// ReadLine reads the next line without trailing spaces. The last line without line break is returned with io.EOF.
+func (p *Prompt) ReadLine() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.readLine()
+}
+
+func (p *Prompt) readLine() (string, error) {
+	line, err := p.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimSpace(line), err
+}
+
+// Ask shows question and reads the answer, def is returned if the answer is empty.
+func (p *Prompt) Ask(question, def string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	answer, err := p.readLine()
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		return def, nil
+	}
+
+	return answer, nil
+}
+
+// AskPassword shows question and reads the answer without echo if the input is a terminal.
+// Spaces of the password are kept.
+func (p *Prompt) AskPassword(question string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fmt.Fprintf(p.out, "%s: ", question)
+	if p.fd < 0 {
+		line, err := p.reader.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	password, err := term.ReadPassword(p.fd)
+	fmt.Fprintln(p.out)
+	if err != nil {
+		return "", err
+	}
+
+	return string(password), nil
+}
+
+// AskNewPassword reads password twice and fails if the entries don't match.
+func (p *Prompt) AskNewPassword(question string) (string, error) {
+	password, err := p.AskPassword(question)
+	if err != nil {
+		return "", err
+	}
+	confirmation, err := p.AskPassword("Repeat " + strings.ToLower(question[:1]) + question[1:])
+	if err != nil {
+		return "", err
+	}
+	if password != confirmation {
+		return "", fmt.Errorf("Passwords don't match")
+	}
+
+	return password, nil
+}
+
+// Confirm asks yes/no question, def is returned if the answer is empty.
+func (p *Prompt) Confirm(question string, def bool) (bool, error) {
+	options := "y/N"
+	if def {
+		options = "Y/n"
+	}
+	for {
+		answer, err := p.Ask(question+" ("+options+")", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
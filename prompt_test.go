@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPromptAsk(t *testing.T) {
+	var out bytes.Buffer
+	prompt := NewPrompt(strings.NewReader("alice\n\n  bob  \n"), &out)
+
+	answers := []string{}
+	for i := 0; i < 3; i++ {
+		answer, err := prompt.Ask("Enter username", "guest")
+		if err != nil {
+			t.Fatalf("[TestPromptAsk] Ask failed: %s", err.Error())
+		}
+		answers = append(answers, answer)
+	}
+	if strings.Join(answers, ",") != "alice,guest,bob" {
+		t.Errorf("[TestPromptAsk] Unexpected answers %v", answers)
+	}
+	if !strings.HasPrefix(out.String(), "Enter username [guest]: ") {
+		t.Errorf("[TestPromptAsk] Unexpected question %q", out.String())
+	}
+	if _, err := prompt.Ask("Enter username", ""); err != io.EOF {
+		t.Errorf("[TestPromptAsk] io.EOF expected, given %v", err)
+	}
+}
+
+func TestPromptPassword(t *testing.T) {
+	var out bytes.Buffer
+	prompt := NewPrompt(strings.NewReader(" secret \n secret \nsecret\nother\nlast"), &out)
+
+	password, err := prompt.AskNewPassword("Enter password")
+	if err != nil || password != " secret " {
+		t.Errorf("[TestPromptPassword] Unexpected password %q %v", password, err)
+	}
+	if !strings.Contains(out.String(), "Repeat enter password: ") {
+		t.Errorf("[TestPromptPassword] Confirmation should be requested, given %q", out.String())
+	}
+	if _, err = prompt.AskNewPassword("Enter password"); err == nil {
+		t.Errorf("[TestPromptPassword] Not matching passwords should fail")
+	}
+	password, err = prompt.AskPassword("Enter password")
+	if err != nil || password != "last" {
+		t.Errorf("[TestPromptPassword] Last line without line break expected, given %q %v", password, err)
+	}
+}
+
+func TestPromptConfirm(t *testing.T) {
+	prompt := NewPrompt(strings.NewReader("y\n\nmaybe\nNo\n\n"), &bytes.Buffer{})
+	expected := []bool{true, false, false, true}
+	defaults := []bool{false, false, true, true}
+	for i := range expected {
+		answer, err := prompt.Confirm("Remove?", defaults[i])
+		if err != nil {
+			t.Fatalf("[TestPromptConfirm] Confirm failed: %s", err.Error())
+		}
+		if answer != expected[i] {
+			t.Errorf("[TestPromptConfirm] #%d Expected=%v;Actual=%v;", i, expected[i], answer)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/time/rate"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits outgoing requests to the server of the profile. Requests are sent at the configured rate
+// (token bucket) and are not sent at all while the server asked to wait by 429 Too Many Requests response.
+type RateLimiter struct {
+	// limiter is nil if the rate is not limited.
+	limiter *rate.Limiter
+	// DefaultPause is the pause after 429 response without Retry-After header.
+	DefaultPause time.Duration
+	// MaxWait is the longest pause a request waits for, zero means no limit. Longer pauses fail the request
+	// at once with PausedError.
+	MaxWait time.Duration
+	// Clock is the real clock if nil.
+	Clock Clock
+	// now returns current time, time.Now if nil.
+	now         func() time.Time
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+// NewRateLimiter creates limiter of requests per second with the burst, zero requests per second means no limit.
+func NewRateLimiter(perSecond float64, burst int) *RateLimiter {
+	limiter := &RateLimiter{DefaultPause: time.Second}
+	if perSecond > 0 {
+		if burst < 1 {
+			burst = 1
+		}
+		limiter.limiter = rate.NewLimiter(rate.Limit(perSecond), burst)
+	}
+	return limiter
+}
+
+// PausedError is returned by RateLimiter.Wait if requests are paused longer than MaxWait.
+type PausedError struct {
+	// Delay is the time left until the end of the pause.
+	Delay time.Duration
+}
+
+func (err *PausedError) Error() string {
+	return fmt.Sprintf("Requests are paused by the server for %s", err.Delay.Round(time.Second))
+}
+
+// Wait blocks until the request could be sent: the pause is over and the rate allows it. It returns the context
+// error if the context is done before and PausedError if the pause is longer than MaxWait.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	// The pause could be extended by other requests while waiting
+	for {
+		d := l.PausedFor()
+		if d <= 0 {
+			break
+		}
+		if l.MaxWait > 0 && d > l.MaxWait {
+			return &PausedError{Delay: d}
+		}
+		select {
+		case <-l.clock().After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if l.limiter == nil {
+		return nil
+	}
+	return l.limiter.Wait(ctx)
+}
+
+// Pause stops sending requests for the duration asked by the server, DefaultPause is used if the duration
+// is not positive. The pause is never shortened by the next calls.
+func (l *RateLimiter) Pause(d time.Duration) time.Duration {
+	if d <= 0 {
+		d = l.DefaultPause
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := l.currentTime().Add(d)
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	return d
+}
+
+// PausedFor returns how long requests are paused, not positive if they are not.
+func (l *RateLimiter) PausedFor() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.pausedUntil.Sub(l.currentTime())
+}
+
+func (l *RateLimiter) clock() Clock {
+	if l.Clock == nil {
+		return realClock{}
+	}
+	return l.Clock
+}
+
+func (l *RateLimiter) currentTime() time.Time {
+	if l.now == nil {
+		return time.Now()
+	}
+	return l.now()
+}
+
+// parseRetryAfter returns the delay of Retry-After header given in seconds or as HTTP date, 0 if there is
+// no header or it's invalid.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// steppingClock moves current time forward by the requested delay, timers fire at once.
+type steppingClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	delays []time.Duration
+}
+
+func (clock *steppingClock) After(d time.Duration) <-chan time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.delays = append(clock.delays, d)
+	clock.now = clock.now.Add(d)
+	c := make(chan time.Time, 1)
+	c <- clock.now
+	return c
+}
+
+func (clock *steppingClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC)
+	cases := map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"-1":                            0,
+		"soon":                          0,
+		"Mon, 01 May 2017 10:00:30 GMT": 30 * time.Second,
+	}
+	for value, expected := range cases {
+		header := http.Header{}
+		if value != "" {
+			header.Set("Retry-After", value)
+		}
+		if actual := parseRetryAfter(header, now); actual != expected {
+			t.Errorf("[TestParseRetryAfter] %q Expected=%s;Actual=%s;", value, expected, actual)
+		}
+	}
+}
+
+func TestRateLimiterPause(t *testing.T) {
+	clock := &steppingClock{now: time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC)}
+	limiter := NewRateLimiter(0, 0)
+	limiter.DefaultPause = time.Second
+	limiter.Clock = clock
+	limiter.now = clock.Now
+
+	if limiter.PausedFor() > 0 {
+		t.Errorf("[TestRateLimiterPause] New limiter should not be paused")
+	}
+	for _, c := range []struct {
+		pause    time.Duration
+		expected time.Duration
+	}{
+		{0, time.Second},
+		{10 * time.Second, 10 * time.Second},
+		{time.Hour, time.Hour},
+		{5 * time.Second, time.Hour},
+	} {
+		limiter.Pause(c.pause)
+		if actual := limiter.PausedFor(); actual != c.expected {
+			t.Errorf("[TestRateLimiterPause] Pause %s Expected=%s;Actual=%s;", c.pause, c.expected, actual)
+		}
+	}
+	err := limiter.Wait(context.Background())
+	if err != nil {
+		t.Fatalf("[TestRateLimiterPause] Wait failed: %s", err.Error())
+	}
+	if len(clock.delays) != 1 || clock.delays[0] != time.Hour || limiter.PausedFor() > 0 {
+		t.Errorf("[TestRateLimiterPause] Expected to wait for the pause once, waited %v", clock.delays)
+	}
+
+	// Pause longer than MaxWait fails the request at once
+	limiter.MaxWait = time.Minute
+	limiter.Pause(time.Hour)
+	err = limiter.Wait(context.Background())
+	if paused, ok := err.(*PausedError); !ok || paused.Delay != time.Hour || len(clock.delays) != 1 {
+		t.Errorf("[TestRateLimiterPause] Expected PausedError of 1h without waiting, given %v %v", err, clock.delays)
+	}
+	limiter.MaxWait = 0
+
+	limiter.Clock = &fakeClock{blocked: true}
+	limiter.Pause(time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Wait(ctx); err != context.Canceled {
+		t.Errorf("[TestRateLimiterPause] Wait should be cancelled, given %v", err)
+	}
+}
+
+func TestRateLimiterRate(t *testing.T) {
+	limiter := NewRateLimiter(50, 1)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		err := limiter.Wait(context.Background())
+		if err != nil {
+			t.Fatalf("[TestRateLimiterRate] Wait failed: %s", err.Error())
+		}
+	}
+	// The first request is sent at once, the next five wait for 20ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("[TestRateLimiterRate] 6 requests at 50 per second are sent in %s", elapsed)
+	}
+}
+
+func TestSendJobRateLimited(t *testing.T) {
+	var requests int32
+	var retryAfter atomic.Value
+	retryAfter.Store("30")
+	limited := int32(2)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/user/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-AUTH-TOKEN", "token")
+	})
+	mux.HandleFunc("/api/item/link/1", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= atomic.LoadInt32(&limited) {
+			w.Header().Set("Retry-After", retryAfter.Load().(string))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	auth, server := newTestAuthServer(t, mux)
+	clock := &steppingClock{now: time.Now()}
+	auth.API = NewAPI(server.URL+"/api/", nil)
+	auth.API.Limiter = NewRateLimiter(0, 0)
+	auth.API.Limiter.Clock = clock
+	auth.API.Limiter.now = clock.Now
+
+	job := Job{ID: "1", Type: JobLinkDelete, LinkID: "1"}
+	jobResult := dispatch(context.Background(), auth, job)
+	e, ok := jobResult.lastError.(*APIError)
+	if !ok || !e.IsRateLimited() || e.RetryAfter() != 30*time.Second || jobResult.IsCorrupted() || jobResult.ConnectionFailed() {
+		t.Fatalf("[TestSendJobRateLimited] Expected rate limit error, got %v", jobResult.lastError)
+	}
+
+	jobResult = sendJob(context.Background(), auth, job)
+	if !jobResult.IsDone() {
+		t.Fatalf("[TestSendJobRateLimited] Job should be done after the pause, got %v", jobResult.lastError)
+	}
+	if atomic.LoadInt32(&requests) != 3 {
+		t.Errorf("[TestSendJobRateLimited] Expected 3 requests, given %d", requests)
+	}
+	// Each 429 response paused requests for 30s
+	if len(clock.delays) != 2 {
+		t.Errorf("[TestSendJobRateLimited] Expected 2 pauses, given %v", clock.delays)
+	}
+	for _, d := range clock.delays {
+		if d <= 29*time.Second || d > 30*time.Second {
+			t.Errorf("[TestSendJobRateLimited] Expected pause of 30s, given %s", d)
+		}
+	}
+
+	// Pause asked by the server is not shortened, the job fails after rateLimitedTries requests
+	retryAfter.Store("3600")
+	atomic.StoreInt32(&limited, 100)
+	atomic.StoreInt32(&requests, 0)
+	clock.delays = nil
+	jobResult = sendJob(context.Background(), auth, job)
+	if !IsRateLimited(jobResult.lastError) || jobResult.IsCorrupted() || atomic.LoadInt32(&requests) != rateLimitedTries {
+		t.Errorf("[TestSendJobRateLimited] Job should fail after %d requests, given %d %v", rateLimitedTries, requests, jobResult.lastError)
+	}
+	if len(clock.delays) != rateLimitedTries-1 || clock.delays[0] <= 59*time.Minute {
+		t.Errorf("[TestSendJobRateLimited] Expected %d pauses of 1h, given %v", rateLimitedTries-1, clock.delays)
+	}
+
+	// The job doesn't wait for the pause longer than MaxWait, it's sent again by the session after the pause
+	auth.API.Limiter.MaxWait = time.Minute
+	atomic.StoreInt32(&requests, 0)
+	clock.delays = nil
+	jobResult = sendJob(context.Background(), auth, job)
+	if jobResult.RetryAfter() <= 59*time.Minute || jobResult.IsCorrupted() || jobResult.ConnectionFailed() {
+		t.Errorf("[TestSendJobRateLimited] Job should fail with the pause, got %v", jobResult.lastError)
+	}
+	if atomic.LoadInt32(&requests) != 0 || len(clock.delays) != 0 {
+		t.Errorf("[TestSendJobRateLimited] Job should not be sent or wait, given %d requests, %v pauses", requests, clock.delays)
+	}
+	auth.API.Limiter.MaxWait = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	atomic.StoreInt32(&requests, 0)
+	auth.API.Limiter.Clock = &fakeClock{blocked: true}
+	jobResult = sendJob(ctx, auth, job)
+	if jobResult.IsDone() || !jobResult.ConnectionFailed() {
+		t.Errorf("[TestSendJobRateLimited] Job should not be sent after cancel, got %v", jobResult.lastError)
+	}
+}
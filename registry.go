@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Command describes console command.
+type Command struct {
+	Name    string
+	Aliases []string
+	// Usage shows arguments of the command, e.g. "show <id>".
+	Usage   string
+	Summary string
+	Handler func(app *App, args []string, out io.Writer) error
+}
+
+// Registry keeps commands by names and aliases.
+type Registry struct {
+	commands []*Command
+	names    map[string]*Command
+}
+
+// NewRegistry creates empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]*Command{}}
+}
+
+// Register adds command to the registry. It panics if the name or one of aliases is already taken,
+// because it's a programming error.
+func (registry *Registry) Register(cmd *Command) {
+	for _, name := range append([]string{cmd.Name}, cmd.Aliases...) {
+		if _, ok := registry.names[name]; ok {
+			panic(fmt.Sprintf("command %s is already registered", name))
+		}
+		registry.names[name] = cmd
+	}
+	registry.commands = append(registry.commands, cmd)
+}
+
+// Find returns command by name or alias, nil if command is not registered.
+func (registry *Registry) Find(name string) *Command {
+	return registry.names[name]
+}
+
+// All returns registered commands sorted by name.
+func (registry *Registry) All() []*Command {
+	all := make([]*Command, len(registry.commands))
+	copy(all, registry.commands)
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name < all[j].Name
+	})
+
+	return all
+}
+
+// Help writes list of commands with short description, or details of the command if name is given.
+func (registry *Registry) Help(out io.Writer, name string) error {
+	if name != "" {
+		cmd := registry.Find(name)
+		if cmd == nil {
+			return &UsageError{fmt.Sprintf("unknown command %s", name)}
+		}
+		fmt.Fprintf(out, "Usage: %s\n", cmd.Usage)
+		if len(cmd.Aliases) > 0 {
+			fmt.Fprintf(out, "Aliases: %s\n", strings.Join(cmd.Aliases, ", "))
+		}
+		fmt.Fprintln(out, cmd.Summary)
+
+		return nil
+	}
+
+	width := 0
+	for _, cmd := range registry.commands {
+		if len(cmd.Usage) > width {
+			width = len(cmd.Usage)
+		}
+	}
+	for _, cmd := range registry.All() {
+		fmt.Fprintf(out, "  %-*s  %s\n", width, cmd.Usage, cmd.Summary)
+	}
+	fmt.Fprintln(out, "Type help <command> for details.")
+
+	return nil
+}
+
+// commands is the registry of all console commands, each command file registers its commands in init().
+var commands = NewRegistry()
+
+func init() {
+	commands.Register(&Command{
+		Name:    "help",
+		Aliases: []string{"?"},
+		Usage:   "help [command]",
+		Summary: "Show list of commands or details of the command.",
+		Handler: func(app *App, args []string, out io.Writer) error {
+			if len(args) > 1 {
+				return &UsageError{"help [command]"}
+			}
+			name := ""
+			if len(args) == 1 {
+				name = args[0]
+			}
+
+			return commands.Help(out, name)
+		},
+	})
+}
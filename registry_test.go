@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	ls := &Command{Name: "ls", Aliases: []string{"list"}, Usage: "ls", Summary: "List links."}
+	rm := &Command{Name: "rm", Usage: "rm <id>", Summary: "Remove link."}
+	registry.Register(rm)
+	registry.Register(ls)
+
+	if registry.Find("list") != ls || registry.Find("ls") != ls || registry.Find("rm") != rm {
+		t.Errorf("[TestRegistry] Commands are not found by name or alias")
+	}
+	if registry.Find("unknown") != nil {
+		t.Errorf("[TestRegistry] Unknown command is found")
+	}
+	all := registry.All()
+	if len(all) != 2 || all[0] != ls || all[1] != rm {
+		t.Errorf("[TestRegistry] Commands are not sorted by name: %v", all)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("[TestRegistry] Registering the same alias twice should panic")
+		}
+	}()
+	registry.Register(&Command{Name: "list"})
+}
+
+func TestRegistryHelp(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(&Command{Name: "ls", Aliases: []string{"list"}, Usage: "ls", Summary: "List links."})
+	registry.Register(&Command{Name: "rm", Usage: "rm <id>", Summary: "Remove link."})
+
+	var out bytes.Buffer
+	err := registry.Help(&out, "")
+	if err != nil {
+		t.Errorf("[TestRegistryHelp] Help failed: %s", err.Error())
+	}
+	expected := "  ls       List links.\n  rm <id>  Remove link.\nType help <command> for details.\n"
+	if out.String() != expected {
+		t.Errorf("[TestRegistryHelp] Expected=%q;Actual=%q;", expected, out.String())
+	}
+
+	out.Reset()
+	err = registry.Help(&out, "list")
+	if err != nil {
+		t.Errorf("[TestRegistryHelp] Help for command failed: %s", err.Error())
+	}
+	expected = "Usage: ls\nAliases: list\nList links.\n"
+	if out.String() != expected {
+		t.Errorf("[TestRegistryHelp] Expected=%q;Actual=%q;", expected, out.String())
+	}
+
+	err = registry.Help(&out, "unknown")
+	if _, ok := err.(*UsageError); !ok {
+		t.Errorf("[TestRegistryHelp] UsageError expected for unknown command, given %v", err)
+	}
+}
+
+func TestAppRun(t *testing.T) {
+	var out bytes.Buffer
+	app := &App{Out: &out}
+
+	err := app.Run("help ping")
+	if err != nil {
+		t.Errorf("[TestAppRun] help failed: %s", err.Error())
+	}
+	if !strings.HasPrefix(out.String(), "Usage: ping\n") {
+		t.Errorf("[TestAppRun] Unexpected help output %q", out.String())
+	}
+
+	err = app.Run("help ping exit")
+	if e, ok := err.(*UsageError); !ok || e.usage != "help [command]" {
+		t.Errorf("[TestAppRun] UsageError expected for wrong arguments, given %v", err)
+	}
+
+	err = app.Run("unknown")
+	if _, ok := err.(*UsageError); !ok {
+		t.Errorf("[TestAppRun] UsageError expected for unknown command, given %v", err)
+	}
+
+	if err = app.Run("quit"); err != ErrExit {
+		t.Errorf("[TestAppRun] ErrExit expected, given %v", err)
+	}
+}
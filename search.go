@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// searchTerm is one term of the search query.
+type searchTerm struct {
+	text   string
+	tag    bool
+	prefix bool
+}
+
+// ParseSearchQuery converts user query into sqlite full-text search expression, depend on simple rules.
+// - if term starts from #, term is a tag filter, the same way as ParseLink reads tags;
+// - if terms are enclosed in double quotes, they are searched as a phrase;
+// - if term ends with *, term is a prefix;
+// - any other term is a word to search in url, description and tags.
+// All terms must match.
+func ParseSearchQuery(query string) (string, error) {
+	terms, err := parseSearchTerms(query)
+	if err != nil {
+		return "", err
+	}
+	expressions := []string{}
+	for _, term := range terms {
+		switch {
+		case term.tag:
+			expressions = append(expressions, "tags:"+quoteSearchTerm(term.text))
+		case term.prefix:
+			expressions = append(expressions, quoteSearchTerm(term.text)+"*")
+		default:
+			expressions = append(expressions, quoteSearchTerm(term.text))
+		}
+	}
+
+	return strings.Join(expressions, " AND "), nil
+}
+
+// tagSearchCondition matches items having the tag, tags are compared ignoring case.
+const tagSearchCondition = "EXISTS (SELECT 1 FROM json_each(items.tags) AS tag WHERE lower(tag.value) = lower(?))"
+
+// tagsSearchQuery returns WHERE condition of items table matching tags of the query exactly and its arguments,
+// the condition is empty if there are no tags in the query. FTS matches tags by words, so it's added next to
+// MATCH, otherwise tag go matches go-kit too.
+func tagsSearchQuery(query string) (string, []interface{}, error) {
+	terms, err := parseSearchTerms(query)
+	if err != nil {
+		return "", nil, err
+	}
+	conditions := []string{}
+	args := []interface{}{}
+	for _, term := range terms {
+		if term.tag {
+			conditions = append(conditions, tagSearchCondition)
+			args = append(args, term.text)
+		}
+	}
+
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// likeSearchQuery converts user query into WHERE condition of items table and its arguments, it is used
+// when sqlite is built without FTS5. Rules are the same as for ParseSearchQuery, but words and phrases
+// match any part of url, description or tags and tags are compared ignoring case.
+func likeSearchQuery(query string) (string, []interface{}, error) {
+	terms, err := parseSearchTerms(query)
+	if err != nil {
+		return "", nil, err
+	}
+	conditions := []string{}
+	args := []interface{}{}
+	for _, term := range terms {
+		if term.tag {
+			conditions = append(conditions, tagSearchCondition)
+			args = append(args, term.text)
+			continue
+		}
+		pattern := "%" + escapeLikePattern(term.text) + "%"
+		conditions = append(conditions,
+			`(url LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\' OR tags LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern, pattern)
+	}
+
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// parseSearchTerms splits user query into terms, see ParseSearchQuery.
+func parseSearchTerms(query string) ([]searchTerm, error) {
+	terms := []searchTerm{}
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		switch {
+		case unicode.IsSpace(runes[i]):
+			i++
+		case runes[i] == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("Phrase is not closed in query: %s", query)
+			}
+			if phrase := strings.TrimSpace(string(runes[i+1 : end])); phrase != "" {
+				terms = append(terms, searchTerm{text: phrase})
+			}
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) {
+				end++
+			}
+			term := string(runes[i:end])
+			i = end
+			switch {
+			case strings.HasPrefix(term, "#"):
+				if len(term) > 1 {
+					terms = append(terms, searchTerm{text: term[1:], tag: true})
+				}
+			case strings.HasSuffix(term, "*"):
+				if len(term) > 1 {
+					terms = append(terms, searchTerm{text: strings.TrimRight(term, "*"), prefix: true})
+				}
+			default:
+				terms = append(terms, searchTerm{text: term})
+			}
+		}
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("Search query is empty")
+	}
+
+	return terms, nil
+}
+
+// quoteSearchTerm wraps term into double quotes, so sqlite doesn't treat it as a query syntax.
+func quoteSearchTerm(term string) string {
+	return `"` + strings.Replace(term, `"`, `""`, -1) + `"`
+}
+
+// escapeLikePattern escapes LIKE wildcards, so term is matched literally.
+func escapeLikePattern(term string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	cases := map[string]string{
+		`golang`:                      `"golang"`,
+		`#go #concurrency`:            `tags:"go" AND tags:"concurrency"`,
+		`"worker pool" #go`:           `"worker pool" AND tags:"go"`,
+		`sched*`:                      `"sched"*`,
+		`http://golang.org/doc`:       `"http://golang.org/doc"`,
+		`  channels   "select case" `: `"channels" AND "select case"`,
+	}
+	for query, expected := range cases {
+		actual, err := ParseSearchQuery(query)
+		if err != nil {
+			t.Errorf("[ParseSearchQuery] %s failed: %s", query, err.Error())
+		}
+		if actual != expected {
+			t.Errorf("[ParseSearchQuery] %s Expected=%s;Actual=%s;", query, expected, actual)
+		}
+	}
+
+	for _, query := range []string{``, `  `, `"not closed`} {
+		_, err := ParseSearchQuery(query)
+		if err == nil {
+			t.Errorf("[ParseSearchQuery] %s error expected", query)
+		}
+	}
+}
+
+func TestItemCacheSearch(t *testing.T) {
+	testItemCacheSearch(t, true)
+}
+
+// TestItemCacheSearchLike checks search of sqlite built without FTS5.
+func TestItemCacheSearchLike(t *testing.T) {
+	testItemCacheSearch(t, false)
+}
+
+func testItemCacheSearch(t *testing.T, fts bool) {
+	os.Remove(TestCacheDBName)
+
+	itemCache, err := NewItemCache(TestCacheDBName)
+	if err != nil {
+		t.Fatalf("[TestItemCacheSearch] Unable to create new cache: %s", err.Error())
+	}
+	cache := itemCache.(*SqliteItemCache)
+	if fts && !cache.fts {
+		t.Skip("[TestItemCacheSearch] sqlite is built without FTS5")
+	}
+	cache.fts = fts
+	links := []*Link{}
+	for _, args := range [][]string{
+		{"https://golang.org/doc/effective_go", "#go", "#docs", "effective go"},
+		{"https://blog.golang.org/pipelines", "#go", "#concurrency", "pipelines and cancellation"},
+		{"https://www.rust-lang.org", "#rust", "systems programming language"},
+		{"https://gokit.io", "#go-kit", "toolkit for microservices"},
+	} {
+		item, _ := ParseLink(args)
+		link := item.(*Link)
+		link.ID = link.URL
+		links = append(links, link)
+	}
+	err = cache.Put(links)
+	if err != nil {
+		t.Fatalf("[TestItemCacheSearch] Unable to put links to the cache: %s", err.Error())
+	}
+	// Put the same link twice to check index is updated and not duplicated
+	links[2].Description = "safe systems language"
+	err = cache.Put(links[2:3])
+	if err != nil {
+		t.Fatalf("[TestItemCacheSearch] Unable to update link in the cache: %s", err.Error())
+	}
+
+	cases := map[string][]string{
+		`#go`:                   {links[0].ID, links[1].ID},
+		`#go-kit`:               {links[3].ID},
+		`#go #concurrency`:      {links[1].ID},
+		`pipe*`:                 {links[1].ID},
+		`"systems language"`:    {links[2].ID},
+		`"systems programming"`: {},
+		`effective`:             {links[0].ID},
+		`#GO #Docs`:             {links[0].ID},
+		`100%`:                  {},
+	}
+	for query, expected := range cases {
+		results, err := cache.Search(query, 10)
+		if err != nil {
+			t.Errorf("[TestItemCacheSearch] fts=%v %s failed: %s", fts, query, err.Error())
+			continue
+		}
+		if len(results) != len(expected) {
+			t.Errorf("[TestItemCacheSearch] fts=%v %s results length Expected=%d;Actual=%d;", fts, query, len(expected), len(results))
+			continue
+		}
+		for _, id := range expected {
+			found := false
+			for _, link := range results {
+				if link.ID == id {
+					found = true
+					break
+				}
+			}
+			if !found {
+				t.Errorf("[TestItemCacheSearch] fts=%v %s: %s not found", fts, query, id)
+			}
+		}
+	}
+
+	err = cache.Remove(links[1].ID)
+	if err != nil {
+		t.Errorf("[TestItemCacheSearch] Unable to remove link from the cache: %s", err.Error())
+	}
+	results, err := cache.Search("#concurrency", 10)
+	if err != nil || len(results) != 0 {
+		t.Errorf("[TestItemCacheSearch] Removed link is still found: %v %v", results, err)
+	}
+}
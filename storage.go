@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
+	"time"
 )
 
 // Storage interface provides methods to use for other code of app, so it doesn't depend on storage implementation.
@@ -12,6 +14,45 @@ type Storage interface {
 	Get(string) ([]byte, error)
 	Remove(string) error
 	ReadAll() ([][]byte, error)
+	Attempt(string) (int, error)
+	SetState(string, string, string) error
+	SetNextAttempt(string, time.Time) error
+	Status(string) (*JobStatus, error)
+	ReadAllStatus(string) ([]*JobStatus, error)
+	MoveToDead(*DeadJob) error
+	ReadAllDead() ([]*DeadJob, error)
+	RetryDead(string) ([]byte, error)
+	PurgeDead() (int, error)
+	PurgeDone(time.Time) (int, error)
+}
+
+// Job states saved with the job.
+const (
+	JobPending  = "pending"
+	JobInFlight = "in-flight"
+	JobDone     = "done"
+	JobFailed   = "failed"
+)
+
+// JobStatus is the saved job with the state of its delivery.
+type JobStatus struct {
+	ID        string
+	Data      []byte
+	State     string
+	Attempts  int
+	LastError string
+	AddedAt   time.Time
+	UpdatedAt time.Time
+}
+
+// DeadJob is a job failed permanently, it's kept apart from the jobs to send until the user retries or purges it.
+type DeadJob struct {
+	ID         string
+	Data       []byte
+	LastError  string
+	StatusCode int
+	Attempts   int
+	FailedAt   time.Time
 }
 
 // SqliteStorage embedded storage.
@@ -34,14 +75,105 @@ func (storage *SqliteStorage) Init() error {
 		addedAt DATETIME,
 		data BLOB
 	);
+	CREATE TABLE IF NOT EXISTS dead_jobs(
+		id TEXT NOT NULL PRIMARY KEY,
+		addedAt DATETIME,
+		failedAt TEXT,
+		data BLOB,
+		lastError TEXT,
+		statusCode INTEGER,
+		attempts INTEGER
+	);
 	`
 	_, err = db.Exec(query)
 	if err != nil {
 		return fmt.Errorf("Storage: unable to create jobs table. %s", err.Error())
 	}
+	// Status columns are added to the jobs table created by previous versions
+	columns := map[string]bool{}
+	rows, err := db.Query("PRAGMA table_info(jobs)")
+	if err != nil {
+		return fmt.Errorf("Storage: unable to read jobs table. %s", err.Error())
+	}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("Storage: unable to read jobs table. %s", err.Error())
+		}
+		columns[name] = true
+	}
+	rows.Close()
+	for _, column := range []struct{ name, definition string }{
+		{"state", "TEXT NOT NULL DEFAULT '" + JobPending + "'"},
+		{"attempts", "INTEGER NOT NULL DEFAULT 0"},
+		{"lastError", "TEXT NOT NULL DEFAULT ''"},
+		{"updatedAt", "DATETIME"},
+		{"nextAttemptAt", "DATETIME"},
+	} {
+		if columns[column.name] {
+			continue
+		}
+		_, err = db.Exec("ALTER TABLE jobs ADD COLUMN " + column.name + " " + column.definition)
+		if err != nil {
+			return fmt.Errorf("Storage: unable to add %s column to jobs table. %s", column.name, err.Error())
+		}
+	}
+	// Jobs in flight when the client stopped are sent again, failed jobs are sent at once by the new session
+	_, err = db.Exec("UPDATE jobs SET state = ? WHERE state = ?", JobPending, JobInFlight)
+	if err != nil {
+		return fmt.Errorf("Storage: unable to reset jobs in flight. %s", err.Error())
+	}
+	_, err = db.Exec("UPDATE jobs SET nextAttemptAt = NULL WHERE nextAttemptAt IS NOT NULL")
+	if err != nil {
+		return fmt.Errorf("Storage: unable to reset next attempts. %s", err.Error())
+	}
+	// Previous versions kept passwords of new users in done and dead jobs
+	err = rewriteData(db, "jobs", withoutPassword, "state = ?", JobDone)
+	if err != nil {
+		return fmt.Errorf("Storage: unable to remove passwords of done jobs. %s", err.Error())
+	}
+	err = rewriteData(db, "dead_jobs", withoutPassword, "1")
+	if err != nil {
+		return fmt.Errorf("Storage: unable to remove passwords from dead jobs. %s", err.Error())
+	}
 	return nil
 }
 
+// rewriteData replaces data of jobs in the table matched by the condition with the result of the function,
+// if it's changed.
+func rewriteData(db *sql.DB, table string, rewrite func([]byte) []byte, where string, args ...interface{}) error {
+	rows, err := db.Query("SELECT id, data FROM "+table+" WHERE "+where, args...)
+	if err != nil {
+		return err
+	}
+	changed := map[string][]byte{}
+	for rows.Next() {
+		var id string
+		var data []byte
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if rewritten := rewrite(data); !bytes.Equal(rewritten, data) {
+			changed[id] = rewritten
+		}
+	}
+	rows.Close()
+	for id, data := range changed {
+		_, err = db.Exec("UPDATE "+table+" SET data = ? WHERE id = ?", data, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 // Put saves job to the storage.
 func (storage *SqliteStorage) Put(id string, data []byte) error {
 	db, err := sql.Open("sqlite3", storage.dbPath)
@@ -120,7 +252,7 @@ func (storage *SqliteStorage) Remove(id string) error {
 	return nil
 }
 
-// ReadAll returns any item needs to be processed.
+// ReadAll returns any item needs to be processed, done jobs and jobs to send later are skipped.
 func (storage *SqliteStorage) ReadAll() ([][]byte, error) {
 	var result [][]byte
 	db, err := sql.Open("sqlite3", storage.dbPath)
@@ -129,7 +261,10 @@ func (storage *SqliteStorage) ReadAll() ([][]byte, error) {
 	}
 	defer db.Close()
 
-	rows, err := db.Query("SELECT data FROM jobs ORDER BY addedAt")
+	rows, err := db.Query(`
+		SELECT data FROM jobs
+		WHERE state != ? AND (nextAttemptAt IS NULL OR nextAttemptAt <= ?)
+		ORDER BY addedAt`, JobDone, time.Now().UTC())
 	if err != nil {
 		return nil, fmt.Errorf("Storage: READALL, query failed. %s", err.Error())
 	}
@@ -150,6 +285,296 @@ func (storage *SqliteStorage) ReadAll() ([][]byte, error) {
 	return result, nil
 }
 
+// Attempt marks the job as in flight and returns the number of attempts to send it including this one.
+func (storage *SqliteStorage) Attempt(id string) (int, error) {
+	db, err := sql.Open("sqlite3", storage.dbPath)
+	if err != nil {
+		return 0, fmt.Errorf("Storage: ATTEMPT %s, open db failed. %s", id, err.Error())
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("Storage: ATTEMPT %s, create transaction failed. %s", id, err.Error())
+	}
+	_, err = tx.Exec("UPDATE jobs SET state = ?, attempts = attempts + 1, updatedAt = ?, nextAttemptAt = NULL WHERE id = ?",
+		JobInFlight, time.Now().UTC(), id)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("Storage: ATTEMPT %s, update failed. %s", id, err.Error())
+	}
+	var attempts int
+	err = tx.QueryRow("SELECT attempts FROM jobs WHERE id = ?", id).Scan(&attempts)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return 0, fmt.Errorf("Storage: ATTEMPT %s, query failed. %s", id, err.Error())
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("Storage: ATTEMPT %s, the transaction commit failed. %s", id, err.Error())
+	}
+
+	return attempts, nil
+}
+
+// SetState saves the state of the job with the error of the last attempt. The password of the new user is removed
+// from the done job.
+func (storage *SqliteStorage) SetState(id string, state string, lastError string) error {
+	db, err := sql.Open("sqlite3", storage.dbPath)
+	if err != nil {
+		return fmt.Errorf("Storage: SETSTATE %s, open db failed. %s", id, err.Error())
+	}
+	defer db.Close()
+
+	_, err = db.Exec("UPDATE jobs SET state = ?, lastError = ?, updatedAt = ? WHERE id = ?",
+		state, lastError, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("Storage: SETSTATE %s, update failed. %s", id, err.Error())
+	}
+	if state == JobDone {
+		err = rewriteData(db, "jobs", withoutPassword, "id = ?", id)
+		if err != nil {
+			return fmt.Errorf("Storage: SETSTATE %s, password removal failed. %s", id, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// SetNextAttempt saves the time the failed job is sent again, ReadAll skips the job until then.
+func (storage *SqliteStorage) SetNextAttempt(id string, at time.Time) error {
+	db, err := sql.Open("sqlite3", storage.dbPath)
+	if err != nil {
+		return fmt.Errorf("Storage: SETNEXTATTEMPT %s, open db failed. %s", id, err.Error())
+	}
+	defer db.Close()
+
+	_, err = db.Exec("UPDATE jobs SET nextAttemptAt = ? WHERE id = ?", at.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("Storage: SETNEXTATTEMPT %s, update failed. %s", id, err.Error())
+	}
+
+	return nil
+}
+
+// Status returns the job with its state, nil if there is no such job.
+func (storage *SqliteStorage) Status(id string) (*JobStatus, error) {
+	db, err := sql.Open("sqlite3", storage.dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Storage: STATUS %s, open db failed. %s", id, err.Error())
+	}
+	defer db.Close()
+
+	row := db.QueryRow("SELECT id, data, state, attempts, lastError, addedAt, updatedAt FROM jobs WHERE id = ?", id)
+	status, err := scanJobStatus(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Storage: STATUS %s, failed to scan. %s", id, err.Error())
+	}
+
+	return status, nil
+}
+
+// ReadAllStatus returns jobs in the given state with their states, all jobs if the state is empty.
+// The most recently added jobs go first.
+func (storage *SqliteStorage) ReadAllStatus(state string) ([]*JobStatus, error) {
+	var result []*JobStatus
+	db, err := sql.Open("sqlite3", storage.dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Storage: READALLSTATUS, open db failed. %s", err.Error())
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT id, data, state, attempts, lastError, addedAt, updatedAt FROM jobs
+		WHERE ? = '' OR state = ?
+		ORDER BY addedAt DESC`, state, state)
+	if err != nil {
+		return nil, fmt.Errorf("Storage: READALLSTATUS, query failed. %s", err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		status, err := scanJobStatus(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Storage: READALLSTATUS, failed to scan. %s", err.Error())
+		}
+		result = append(result, status)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Storage: READALLSTATUS, reading data failed. %s", err.Error())
+	}
+
+	return result, nil
+}
+
+// scanJobStatus reads jobs row into new job status.
+func scanJobStatus(row rowScanner) (*JobStatus, error) {
+	var updatedAt sql.NullTime
+	status := &JobStatus{}
+	err := row.Scan(&status.ID, &status.Data, &status.State, &status.Attempts, &status.LastError, &status.AddedAt, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	status.UpdatedAt = status.AddedAt
+	if updatedAt.Valid {
+		status.UpdatedAt = updatedAt.Time
+	}
+
+	return status, nil
+}
+
+// MoveToDead removes the job from the jobs to send and saves it as dead with the failure details.
+func (storage *SqliteStorage) MoveToDead(dead *DeadJob) error {
+	db, err := sql.Open("sqlite3", storage.dbPath)
+	if err != nil {
+		return fmt.Errorf("Storage: MOVETODEAD %s, open db failed. %s", dead.ID, err.Error())
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Storage: MOVETODEAD %s, create transaction failed. %s", dead.ID, err.Error())
+	}
+	// Keep the time the job was added, if it's saved
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO dead_jobs(id, addedAt, failedAt, data, lastError, statusCode, attempts)
+		VALUES(?, COALESCE((SELECT addedAt FROM jobs WHERE id = ?), datetime('now')), ?, ?, ?, ?, ?)`,
+		dead.ID, dead.ID, dead.FailedAt.UTC().Format(time.RFC3339Nano), dead.Data, dead.LastError, dead.StatusCode, dead.Attempts)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Storage: MOVETODEAD %s, insert failed. %s", dead.ID, err.Error())
+	}
+	_, err = tx.Exec("DELETE FROM jobs WHERE id = ?", dead.ID)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Storage: MOVETODEAD %s, delete query failed. %s", dead.ID, err.Error())
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Storage: MOVETODEAD %s, the transaction commit failed. %s", dead.ID, err.Error())
+	}
+
+	return nil
+}
+
+// ReadAllDead returns dead jobs, the most recently failed first.
+func (storage *SqliteStorage) ReadAllDead() ([]*DeadJob, error) {
+	var result []*DeadJob
+	db, err := sql.Open("sqlite3", storage.dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Storage: READALLDEAD, open db failed. %s", err.Error())
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, failedAt, data, lastError, statusCode, attempts FROM dead_jobs ORDER BY failedAt DESC")
+	if err != nil {
+		return nil, fmt.Errorf("Storage: READALLDEAD, query failed. %s", err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var failedAt string
+		dead := &DeadJob{}
+		err = rows.Scan(&dead.ID, &failedAt, &dead.Data, &dead.LastError, &dead.StatusCode, &dead.Attempts)
+		if err != nil {
+			return nil, fmt.Errorf("Storage: READALLDEAD, failed to scan. %s", err.Error())
+		}
+		dead.FailedAt, err = time.Parse(time.RFC3339Nano, failedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Storage: READALLDEAD, unable to parse %s. %s", failedAt, err.Error())
+		}
+		result = append(result, dead)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Storage: READALLDEAD, reading data failed. %s", err.Error())
+	}
+
+	return result, nil
+}
+
+// RetryDead moves the dead job back to the jobs to send and returns its data, nil if there is no such dead job.
+func (storage *SqliteStorage) RetryDead(id string) ([]byte, error) {
+	db, err := sql.Open("sqlite3", storage.dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("Storage: RETRYDEAD %s, open db failed. %s", id, err.Error())
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("Storage: RETRYDEAD %s, create transaction failed. %s", id, err.Error())
+	}
+	var data []byte
+	err = tx.QueryRow("SELECT data FROM dead_jobs WHERE id = ?", id).Scan(&data)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil, nil
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("Storage: RETRYDEAD %s, query failed. %s", id, err.Error())
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO jobs(id, addedAt, data) SELECT id, addedAt, data FROM dead_jobs WHERE id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("Storage: RETRYDEAD %s, insert failed. %s", id, err.Error())
+	}
+	_, err = tx.Exec("DELETE FROM dead_jobs WHERE id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("Storage: RETRYDEAD %s, delete query failed. %s", id, err.Error())
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("Storage: RETRYDEAD %s, the transaction commit failed. %s", id, err.Error())
+	}
+
+	return data, nil
+}
+
+// PurgeDead removes all dead jobs and returns their number.
+func (storage *SqliteStorage) PurgeDead() (int, error) {
+	db, err := sql.Open("sqlite3", storage.dbPath)
+	if err != nil {
+		return 0, fmt.Errorf("Storage: PURGEDEAD, open db failed. %s", err.Error())
+	}
+	defer db.Close()
+
+	res, err := db.Exec("DELETE FROM dead_jobs")
+	if err != nil {
+		return 0, fmt.Errorf("Storage: PURGEDEAD, delete query failed. %s", err.Error())
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("Storage: PURGEDEAD, delete query failed. %s", err.Error())
+	}
+
+	return int(n), nil
+}
+
+// PurgeDone removes jobs done before the given time and returns their number.
+func (storage *SqliteStorage) PurgeDone(before time.Time) (int, error) {
+	db, err := sql.Open("sqlite3", storage.dbPath)
+	if err != nil {
+		return 0, fmt.Errorf("Storage: PURGEDONE, open db failed. %s", err.Error())
+	}
+	defer db.Close()
+
+	res, err := db.Exec("DELETE FROM jobs WHERE state = ? AND COALESCE(updatedAt, addedAt) < ?", JobDone, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("Storage: PURGEDONE, delete query failed. %s", err.Error())
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("Storage: PURGEDONE, delete query failed. %s", err.Error())
+	}
+
+	return int(n), nil
+}
+
 // NewStorage create new storage entity.
 func NewStorage(dbPath string) (Storage, error) {
 	if dbPath == "" {
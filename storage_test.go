@@ -2,9 +2,13 @@ package main
 
 import (
 	"bytes"
+	"database/sql"
 	"fmt"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 const TestDBName string = "testdata/test.db"
@@ -90,3 +94,178 @@ func TestReadAll(t *testing.T) {
 		}
 	}
 }
+
+func TestDeadJobs(t *testing.T) {
+	os.Remove(TestDBName)
+
+	storage, err := NewStorage(TestDBName)
+	if err != nil {
+		t.Fatalf("[TestDeadJobs] Unable to create new storage: %s", err.Error())
+	}
+	data := []byte(`{"id":"id1","type":"link.create","link":{"url":"http://google.com"}}`)
+	err = storage.Put("id1", data)
+	if err != nil {
+		t.Errorf("[TestDeadJobs] Unable to put data to the storage: %s", err.Error())
+	}
+	failedAt := time.Date(2017, 5, 1, 10, 0, 0, 0, time.UTC)
+	err = storage.MoveToDead(&DeadJob{ID: "id1", Data: data, LastError: "400", StatusCode: 400, Attempts: 1, FailedAt: failedAt})
+	if err != nil {
+		t.Errorf("[TestDeadJobs] Unable to move job to dead: %s", err.Error())
+	}
+
+	results, err := storage.ReadAll()
+	if err != nil || len(results) != 0 {
+		t.Errorf("[TestDeadJobs] Dead job should not be read with jobs to send, given %d %v", len(results), err)
+	}
+	dead, err := storage.ReadAllDead()
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("[TestDeadJobs] One dead job expected, given %d %v", len(dead), err)
+	}
+	expected := &DeadJob{ID: "id1", Data: data, LastError: "400", StatusCode: 400, Attempts: 1, FailedAt: failedAt}
+	if !reflect.DeepEqual(dead[0], expected) {
+		t.Errorf("[TestDeadJobs] Expected %v is not equal to given %v", expected, dead[0])
+	}
+
+	retried, err := storage.RetryDead("id1")
+	if err != nil || !bytes.Equal(retried, data) {
+		t.Errorf("[TestDeadJobs] Unexpected retried job %s %v", string(retried), err)
+	}
+	results, err = storage.ReadAll()
+	if err != nil || len(results) != 1 {
+		t.Errorf("[TestDeadJobs] Retried job should be read with jobs to send, given %d %v", len(results), err)
+	}
+	retried, err = storage.RetryDead("id1")
+	if err != nil || retried != nil {
+		t.Errorf("[TestDeadJobs] Retried job should not be dead, given %s %v", string(retried), err)
+	}
+
+	// Job without payload is buried as invalid and still could be listed
+	empty := []byte(`{"id":"id3","type":"user.create"}`)
+	storage.MoveToDead(&DeadJob{ID: "id3", Data: empty, FailedAt: failedAt})
+	dead, err = storage.ReadAllDead()
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("[TestDeadJobs] One dead job expected, given %d %v", len(dead), err)
+	}
+	expectedDescription := "Job #id3 of type user.create has no payload"
+	if description := describeJob(dead[0].Data); description != expectedDescription {
+		t.Errorf("[TestDeadJobs] Expected=%s;Actual=%s;", expectedDescription, description)
+	}
+
+	storage.MoveToDead(&DeadJob{ID: "id1", Data: data, FailedAt: failedAt})
+	storage.MoveToDead(&DeadJob{ID: "id2", Data: data, FailedAt: failedAt})
+	n, err := storage.PurgeDead()
+	if err != nil || n != 3 {
+		t.Errorf("[TestDeadJobs] Three dead jobs should be purged, given %d %v", n, err)
+	}
+	dead, err = storage.ReadAllDead()
+	if err != nil || len(dead) != 0 {
+		t.Errorf("[TestDeadJobs] No dead jobs expected after purge, given %d %v", len(dead), err)
+	}
+}
+
+func TestJobStatus(t *testing.T) {
+	os.Remove(TestDBName)
+
+	// Jobs table of previous versions without status columns
+	db, err := sql.Open("sqlite3", TestDBName)
+	if err != nil {
+		t.Fatalf("[TestJobStatus] Unable to create db: %s", err.Error())
+	}
+	_, err = db.Exec(`
+	CREATE TABLE jobs(id TEXT NOT NULL PRIMARY KEY, addedAt DATETIME, data BLOB);
+	INSERT INTO jobs(id, addedAt, data) VALUES('id1', datetime('now', '-1 minute'), '{"url":"http://google.com"}');
+	`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("[TestJobStatus] Unable to create jobs table: %s", err.Error())
+	}
+
+	storage, err := NewStorage(TestDBName)
+	if err != nil {
+		t.Fatalf("[TestJobStatus] Unable to open storage: %s", err.Error())
+	}
+	status, err := storage.Status("id1")
+	if err != nil || status == nil || status.State != JobPending || status.Attempts != 0 || status.AddedAt.IsZero() {
+		t.Errorf("[TestJobStatus] Saved job should be pending, given %v %v", status, err)
+	}
+	err = storage.Put("id2", []byte(`{"url":"http://yahoo.com"}`))
+	if err != nil {
+		t.Errorf("[TestJobStatus] Unable to put data to the storage: %s", err.Error())
+	}
+
+	for i := 1; i <= 2; i++ {
+		attempts, err := storage.Attempt("id1")
+		if err != nil || attempts != i {
+			t.Errorf("[TestJobStatus] Attempt %d expected, given %d %v", i, attempts, err)
+		}
+	}
+	err = storage.SetState("id1", JobFailed, "503")
+	if err != nil {
+		t.Errorf("[TestJobStatus] Unable to set state: %s", err.Error())
+	}
+	status, err = storage.Status("id1")
+	if err != nil || status.State != JobFailed || status.Attempts != 2 || status.LastError != "503" || status.UpdatedAt.Before(status.AddedAt) {
+		t.Errorf("[TestJobStatus] Unexpected failed job %v %v", status, err)
+	}
+
+	storage.SetState("id2", JobDone, "")
+	results, err := storage.ReadAll()
+	if err != nil || len(results) != 1 {
+		t.Errorf("[TestJobStatus] Done job should not be sent again, given %d %v", len(results), err)
+	}
+	done, err := storage.ReadAllStatus(JobDone)
+	if err != nil || len(done) != 1 || done[0].ID != "id2" {
+		t.Errorf("[TestJobStatus] One done job expected, given %v %v", done, err)
+	}
+	all, err := storage.ReadAllStatus("")
+	if err != nil || len(all) != 2 {
+		t.Errorf("[TestJobStatus] Two jobs expected, given %v %v", all, err)
+	}
+
+	// Password of the done job is removed, passwords are not kept in dead jobs of previous versions
+	userJob := []byte(`{"id":"id3","type":"user.create","user":{"username":"user","password":"pass"}}`)
+	storage.Put("id3", userJob)
+	storage.SetState("id3", JobDone, "")
+	status, err = storage.Status("id3")
+	if err != nil || string(status.Data) != `{"id":"id3","type":"user.create","user":{"username":"user","password":""}}` {
+		t.Errorf("[TestJobStatus] Done job should have no password, given %v %v", status, err)
+	}
+	storage.MoveToDead(&DeadJob{ID: "id4", Data: userJob, FailedAt: time.Now()})
+
+	// Job in flight when the client stopped is pending on the next start
+	storage.Attempt("id1")
+	storage, err = NewStorage(TestDBName)
+	if err != nil {
+		t.Fatalf("[TestJobStatus] Unable to open storage: %s", err.Error())
+	}
+	dead, err := storage.ReadAllDead()
+	if err != nil || len(dead) != 1 || strings.Contains(string(dead[0].Data), `"pass"`) || !strings.Contains(string(dead[0].Data), "user") {
+		t.Errorf("[TestJobStatus] Password should be removed from dead job, given %v %v", dead, err)
+	}
+	status, err = storage.Status("id2")
+	if err != nil || status.State != JobDone || !strings.Contains(string(status.Data), "http://yahoo.com") {
+		t.Errorf("[TestJobStatus] Done link job should keep its link, given %v %v", status, err)
+	}
+	status, err = storage.Status("id1")
+	if err != nil || status.State != JobPending || status.Attempts != 3 {
+		t.Errorf("[TestJobStatus] Job in flight should be pending, given %v %v", status, err)
+	}
+	status, err = storage.Status("unknown")
+	if err != nil || status != nil {
+		t.Errorf("[TestJobStatus] No job expected, given %v %v", status, err)
+	}
+
+	// Done jobs are removed after the retention time, other jobs are kept
+	n, err := storage.PurgeDone(time.Now().Add(-time.Hour))
+	if err != nil || n != 0 {
+		t.Errorf("[TestJobStatus] Recently done jobs should be kept, given %d %v", n, err)
+	}
+	n, err = storage.PurgeDone(time.Now())
+	if err != nil || n != 2 {
+		t.Errorf("[TestJobStatus] Two done jobs should be removed, given %d %v", n, err)
+	}
+	all, err = storage.ReadAllStatus("")
+	if err != nil || len(all) != 1 || all[0].ID != "id1" {
+		t.Errorf("[TestJobStatus] Pending job should be kept, given %v %v", all, err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tag is a tag name with number of items tagged with it.
+type Tag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// TagChange replaces tags From with tag To in all items. Empty To removes the tags. Rename is a change
+// with one tag From, merge is a change with several tags From.
+type TagChange struct {
+	From []string `json:"from"`
+	To   string   `json:"to,omitempty"`
+}
+
+// String describes the change for console messages.
+func (change *TagChange) String() string {
+	if change.To == "" {
+		return "remove #" + strings.Join(change.From, " #")
+	}
+	return fmt.Sprintf("#%s to #%s", strings.Join(change.From, " #"), change.To)
+}
+
+// Apply returns tags with the change applied, duplicates made by merge are removed. changed is false
+// if no tag matched the change.
+func (change *TagChange) Apply(tags []string) (result []string, changed bool) {
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		for _, from := range change.From {
+			if tag == from {
+				tag = change.To
+				changed = true
+				break
+			}
+		}
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+
+	return result, changed
+}
+
+// ParseTagName strips leading # of the tag name given in console.
+func ParseTagName(arg string) (string, error) {
+	name := strings.TrimPrefix(arg, "#")
+	if name == "" || strings.ContainsAny(name, "# \t") {
+		return "", fmt.Errorf("Wrong tag name %s", arg)
+	}
+
+	return name, nil
+}
+
+// ParseTagMerge parses tags of merge command: a b c into d.
+func ParseTagMerge(args []string) (*TagChange, error) {
+	if len(args) < 3 || args[len(args)-2] != "into" {
+		return nil, errWrongArgs
+	}
+	change := &TagChange{}
+	for _, arg := range args[:len(args)-2] {
+		name, err := ParseTagName(arg)
+		if err != nil {
+			return nil, err
+		}
+		change.From = append(change.From, name)
+	}
+	to, err := ParseTagName(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+	change.To = to
+
+	return change, nil
+}
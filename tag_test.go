@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTagChangeApply(t *testing.T) {
+	for _, c := range []struct {
+		change  *TagChange
+		tags    []string
+		result  []string
+		changed bool
+	}{
+		{&TagChange{From: []string{"go"}, To: "golang"}, []string{"go", "web"}, []string{"golang", "web"}, true},
+		{&TagChange{From: []string{"go", "golang"}, To: "lang"}, []string{"go", "golang", "lang"}, []string{"lang"}, true},
+		{&TagChange{From: []string{"web"}}, []string{"go", "web"}, []string{"go"}, true},
+		{&TagChange{From: []string{"web"}}, []string{"go"}, []string{"go"}, false},
+	} {
+		result, changed := c.change.Apply(c.tags)
+		if !reflect.DeepEqual(result, c.result) || changed != c.changed {
+			t.Errorf("[TestTagChangeApply] %s of %v: expected %v %t, given %v %t", c.change, c.tags, c.result, c.changed, result, changed)
+		}
+	}
+}
+
+func TestParseTagMerge(t *testing.T) {
+	change, err := ParseTagMerge([]string{"#a", "b", "c", "into", "d"})
+	if err != nil || !reflect.DeepEqual(change, &TagChange{From: []string{"a", "b", "c"}, To: "d"}) {
+		t.Errorf("[TestParseTagMerge] Unexpected change %v %v", change, err)
+	}
+	for _, args := range [][]string{{"a", "b"}, {"into", "d"}, {"a", "b", "into"}, {"a", "into", "#"}} {
+		_, err = ParseTagMerge(args)
+		if err == nil {
+			t.Errorf("[TestParseTagMerge] Error expected for %v", args)
+		}
+	}
+}
+
+func TestItemCacheTags(t *testing.T) {
+	os.Remove(TestCacheDBName)
+
+	cache, err := NewItemCache(TestCacheDBName)
+	if err != nil {
+		t.Fatalf("[TestItemCacheTags] Unable to create new cache: %s", err.Error())
+	}
+	links := []*Link{}
+	for i, tags := range [][]string{{"go", "web"}, {"golang"}, {"web"}, nil} {
+		link := &Link{}
+		link.ID = string(rune('a' + i))
+		link.URL = "http://example.com/" + link.ID
+		link.Tags = tags
+		link.UpdatedAt = time.Date(2017, 5, 1, 10, i, 0, 0, time.UTC)
+		links = append(links, link)
+	}
+	err = cache.Put(links)
+	if err != nil {
+		t.Fatalf("[TestItemCacheTags] Unable to put links to the cache: %s", err.Error())
+	}
+
+	tags, err := cache.Tags()
+	expected := []*Tag{{"go", 1}, {"golang", 1}, {"web", 2}}
+	if err != nil || !reflect.DeepEqual(tags, expected) {
+		t.Errorf("[TestItemCacheTags] Unexpected tags %v %v", tags, err)
+	}
+
+	n, err := cache.ChangeTags(&TagChange{From: []string{"go", "golang"}, To: "lang"})
+	if err != nil || n != 2 {
+		t.Errorf("[TestItemCacheTags] Unexpected merge result %d %v", n, err)
+	}
+	n, err = cache.ChangeTags(&TagChange{From: []string{"web"}})
+	if err != nil || n != 2 {
+		t.Errorf("[TestItemCacheTags] Unexpected remove result %d %v", n, err)
+	}
+	tags, err = cache.Tags()
+	expected = []*Tag{{"lang", 2}}
+	if err != nil || !reflect.DeepEqual(tags, expected) {
+		t.Errorf("[TestItemCacheTags] Unexpected tags after change %v %v", tags, err)
+	}
+	// Search index follows the change
+	found, err := cache.Search("#lang", 10)
+	if err != nil || len(found) != 2 {
+		t.Errorf("[TestItemCacheTags] Changed links are not found by tag %v %v", found, err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+)
+
+// SPKIPinPrefix is the prefix of SPKI pin, the rest of the pin is base64 encoded SHA-256 of the certificate
+// public key (as in HPKP and curl --pinnedpubkey).
+const SPKIPinPrefix = "sha256/"
+
+// tlsVersions maps configuration values to TLS versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// PinMismatchError is returned by handshake if no certificate of the server chain matches the configured pins.
+type PinMismatchError struct {
+	pins []string
+}
+
+func (err *PinMismatchError) Error() string {
+	return fmt.Sprintf("server certificate does not match tls_pin, server pins: %s", strings.Join(err.pins, ", "))
+}
+
+// ParseTLSVersion returns TLS version of the configuration value, e.g. 1.2.
+func ParseTLSVersion(value string) (uint16, error) {
+	version, ok := tlsVersions[value]
+	if !ok {
+		return 0, fmt.Errorf("tls_min_version should be one of 1.0, 1.1, 1.2 or 1.3, given %q", value)
+	}
+	return version, nil
+}
+
+// ParseSPKIPin decodes the pin like sha256/<base64> to SHA-256 hash of the public key.
+func ParseSPKIPin(pin string) ([]byte, error) {
+	if !strings.HasPrefix(pin, SPKIPinPrefix) {
+		return nil, fmt.Errorf("tls_pin should be like %s<base64 of public key hash>, given %q", SPKIPinPrefix, pin)
+	}
+	hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, SPKIPinPrefix))
+	if err != nil || len(hash) != sha256.Size {
+		return nil, fmt.Errorf("tls_pin should have base64 encoded SHA-256 hash, given %q", pin)
+	}
+	return hash, nil
+}
+
+// SPKIPin returns the pin of the certificate public key.
+func SPKIPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return SPKIPinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// NewTLSConfig builds TLS configuration of the connection to the server from the configuration options.
+// Pins are checked in addition to the usual verification of the server certificate.
+func NewTLSConfig(config *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.TLSMinVersion != "" {
+		version, err := ParseTLSVersion(config.TLSMinVersion)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.MinVersion = version
+	}
+	if config.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(config.resolvePath(config.TLSCAFile))
+		if err != nil {
+			return nil, fmt.Errorf("Reading CA bundle failed: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA bundle %s has no PEM certificates", config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+			return nil, fmt.Errorf("Client certificate requires both tls_cert_file and tls_key_file")
+		}
+		cert, err := tls.LoadX509KeyPair(config.resolvePath(config.TLSCertFile), config.resolvePath(config.TLSKeyFile))
+		if err != nil {
+			return nil, fmt.Errorf("Loading client certificate failed: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if len(config.TLSPins) > 0 {
+		hashes := [][]byte{}
+		for _, pin := range config.TLSPins {
+			hash, err := ParseSPKIPin(pin)
+			if err != nil {
+				return nil, err
+			}
+			hashes = append(hashes, hash)
+		}
+		// Verified chains end with the trusted CA, which the server usually doesn't send
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPins(state.VerifiedChains, hashes)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// verifyPins checks that one of the certificates of the verified server chains has pinned public key.
+func verifyPins(chains [][]*x509.Certificate, hashes [][]byte) error {
+	pins := []string{}
+	seen := map[string]bool{}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pinned := range hashes {
+				if bytes.Equal(hash[:], pinned) {
+					return nil
+				}
+			}
+			if pin := SPKIPin(cert); !seen[pin] {
+				seen[pin] = true
+				pins = append(pins, pin)
+			}
+		}
+	}
+	return &PinMismatchError{pins}
+}
+
+// describeTLSError explains why the TLS handshake failed and which option to check, empty if the error is not
+// a handshake failure.
+func describeTLSError(err error) string {
+	var pinErr *PinMismatchError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &pinErr):
+		return "TLS handshake failed: " + pinErr.Error()
+	case errors.As(err, &unknownAuthority):
+		return "TLS handshake failed: server certificate is signed by unknown authority, set tls_ca_file to the CA bundle of the server"
+	case errors.As(err, &hostnameErr):
+		return "TLS handshake failed: " + hostnameErr.Error() + ", check api_host"
+	case errors.As(err, &invalidErr):
+		return "TLS handshake failed: server certificate is invalid: " + invalidErr.Error()
+	case errors.As(err, &recordErr):
+		return "TLS handshake failed: server does not speak TLS, check api_host scheme"
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		return "TLS handshake failed: server rejected the connection (" + opErr.Err.Error() + "), check tls_cert_file, tls_key_file and tls_min_version"
+	}
+	return ""
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCertificate creates the key and the certificate signed by the parent (self-signed if parent is nil)
+// and writes them to PEM files in the dir.
+func writeCertificate(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("[writeCertificate] Unable to generate key: %s", err.Error())
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("[writeCertificate] Unable to create certificate: %s", err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("[writeCertificate] Unable to parse certificate: %s", err.Error())
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("[writeCertificate] Unable to encode key: %s", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatalf("[writeCertificate] Unable to write certificate: %s", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatalf("[writeCertificate] Unable to write key: %s", err.Error())
+	}
+
+	return cert, key
+}
+
+func TestTLSOptions(t *testing.T) {
+	dir := t.TempDir()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "lmc test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	ca, caKey := writeCertificate(t, dir, "ca", caTemplate, nil, nil)
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "user"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	writeCertificate(t, dir, "client", clientTemplate, ca, caKey)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	// Handshake failures are expected, don't log them
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs, MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+	err := ioutil.WriteFile(filepath.Join(dir, "server.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatalf("[TestTLSOptions] Unable to write server certificate: %s", err.Error())
+	}
+	mTLSServer := httptest.NewUnstartedServer(server.Config.Handler)
+	mTLSServer.Config.ErrorLog = server.Config.ErrorLog
+	mTLSServer.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	mTLSServer.StartTLS()
+	defer mTLSServer.Close()
+
+	// Server certificate signed by the CA, the server doesn't send the CA certificate
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	leaf, _ := writeCertificate(t, dir, "leaf", leafTemplate, ca, caKey)
+	leafCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "leaf.crt"), filepath.Join(dir, "leaf.key"))
+	if err != nil {
+		t.Fatalf("[TestTLSOptions] Unable to load server certificate: %s", err.Error())
+	}
+	caServer := httptest.NewUnstartedServer(server.Config.Handler)
+	caServer.Config.ErrorLog = server.Config.ErrorLog
+	caServer.TLS = &tls.Config{Certificates: []tls.Certificate{leafCert}}
+	caServer.StartTLS()
+	defer caServer.Close()
+
+	serverPin := SPKIPin(server.Certificate())
+	cases := []struct {
+		name   string
+		server *httptest.Server
+		config Config
+		// errorContains is empty if ping should succeed.
+		errorContains string
+	}{
+		{"unknown authority", server, Config{}, "tls_ca_file"},
+		{"CA bundle", server, Config{TLSCAFile: "server.crt"}, ""},
+		{"absolute CA bundle path", server, Config{TLSCAFile: filepath.Join(dir, "server.crt")}, ""},
+		{"pin", server, Config{TLSCAFile: "server.crt", TLSPins: []string{SPKIPin(ca), serverPin}}, ""},
+		{"wrong pin", server, Config{TLSCAFile: "server.crt", TLSPins: []string{SPKIPin(ca)}}, serverPin},
+		{"CA pin", caServer, Config{TLSCAFile: "ca.crt", TLSPins: []string{SPKIPin(ca)}}, ""},
+		{"wrong CA pin", caServer, Config{TLSCAFile: "ca.crt", TLSPins: []string{serverPin}}, SPKIPin(leaf)},
+		{"client certificate", server, Config{TLSCAFile: "server.crt", TLSCertFile: "client.crt", TLSKeyFile: "client.key"}, ""},
+		{"min version", server, Config{TLSCAFile: "server.crt", TLSMinVersion: "1.3"}, "tls_min_version"},
+		{"mTLS", mTLSServer, Config{TLSCAFile: "server.crt", TLSCertFile: "client.crt", TLSKeyFile: "client.key"}, ""},
+		{"mTLS without client certificate", mTLSServer, Config{TLSCAFile: "server.crt"}, "tls_cert_file"},
+	}
+	for _, c := range cases {
+		config := c.config
+		config.Dir = dir
+		config.HTTPTimeout = 10 * time.Second
+		client, err := NewHTTPClient(&config)
+		if err != nil {
+			t.Errorf("[TestTLSOptions] %s: unable to create client: %s", c.name, err.Error())
+			continue
+		}
+		err = NewAPI(c.server.URL+"/api/", client).Ping(context.Background())
+		if c.errorContains == "" && err != nil {
+			t.Errorf("[TestTLSOptions] %s: ping failed: %s", c.name, err.Error())
+		}
+		if c.errorContains != "" {
+			if _, ok := err.(*APIConnectionFailed); !ok || !strings.Contains(err.Error(), c.errorContains) {
+				t.Errorf("[TestTLSOptions] %s: expected handshake error with %q, got %v", c.name, c.errorContains, err)
+			}
+		}
+	}
+
+	invalid := []Config{
+		{TLSCAFile: "missing.crt"},
+		{TLSCAFile: "client.key"},
+		{TLSCertFile: "client.crt"},
+		{TLSCertFile: "client.crt", TLSKeyFile: "ca.key"},
+		{TLSMinVersion: "1.4"},
+	}
+	for _, config := range invalid {
+		config.Dir = dir
+		_, err := NewHTTPClient(&config)
+		if err == nil {
+			t.Errorf("[TestTLSOptions] Expected TLS configuration error for %+v", config)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/net/http/httpproxy"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// UnixScheme is the scheme of API host, which is a Unix domain socket, e.g. unix:///run/links-manager.sock
+	UnixScheme = "unix"
+	// UnixAPIPath is the path of the API on the server listening to the Unix domain socket.
+	UnixAPIPath = "/api/"
+	// ProxyDirect disables proxy given by environment variables.
+	ProxyDirect = "direct"
+)
+
+// proxySchemes are schemes of the proxy url supported by the transport.
+var proxySchemes = map[string]bool{"http": true, "https": true, "socks5": true, "socks5h": true}
+
+// NewTransport creates the transport of requests to the server: connections are made with the TLS options,
+// through the proxy or to the Unix domain socket of API host. Idle connections are kept to reuse them.
+func NewTransport(config *Config) (http.RoundTripper, error) {
+	tlsConfig, err := NewTLSConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("TLS configuration failed: %s", err.Error())
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 4
+	transport.IdleConnTimeout = 90 * time.Second
+	transport.TLSClientConfig = tlsConfig
+
+	if socket, ok := unixSocket(config.APIHost); ok {
+		// Requests are never proxied, whatever the host of the request is, connection is made to the socket
+		transport.Proxy = nil
+		dialer := &net.Dialer{Timeout: 30 * time.Second}
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		return &unixTransport{socket: socket, transport: transport}, nil
+	}
+	proxy, err := newProxyFunc(config.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = proxy
+
+	return transport, nil
+}
+
+// newProxyFunc returns the function selecting the proxy of the request. The proxy is taken from
+// HTTPS_PROXY and HTTP_PROXY variables if it's not given. NO_PROXY is honored in both cases.
+func newProxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	if proxy == ProxyDirect {
+		return nil, nil
+	}
+	proxyConfig := httpproxy.FromEnvironment()
+	if proxy != "" {
+		err := validateProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxyConfig.HTTPProxy = proxy
+		proxyConfig.HTTPSProxy = proxy
+	}
+	proxyFunc := proxyConfig.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}, nil
+}
+
+// validateProxy checks the proxy is url with supported scheme.
+func validateProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil || !proxySchemes[u.Scheme] || u.Host == "" {
+		return fmt.Errorf("proxy should be http(s) or socks5 url like socks5://localhost:1080 or %s, given %q", ProxyDirect, proxy)
+	}
+	return nil
+}
+
+// unixSocket returns path of the socket if API host is unix:///path/to/socket.
+func unixSocket(apiHost string) (string, bool) {
+	if !strings.HasPrefix(apiHost, UnixScheme+"://") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(apiHost, UnixScheme+"://"), "/"), true
+}
+
+// validateSocket checks the socket path of unix:// API host is absolute.
+func validateSocket(apiHost string) error {
+	socket, _ := unixSocket(apiHost)
+	u, err := url.Parse(apiHost)
+	if err != nil || u.Host != "" || !strings.HasPrefix(socket, "/") || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("api_host should be %s:///path/to/socket, given %q", UnixScheme, apiHost)
+	}
+	return nil
+}
+
+// unixTransport sends requests to unix:///path/to/socket/<endpoint> to the server listening to the socket
+// as HTTP requests to UnixAPIPath<endpoint>.
+type unixTransport struct {
+	socket    string
+	transport *http.Transport
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *unixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != UnixScheme || !strings.HasPrefix(req.URL.Path, t.socket+"/") {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("Request %s is not to the socket %s", req.URL, t.socket)
+	}
+	// Escaped path is changed to keep escaped slashes of the endpoint, e.g. in tag names
+	socket := (&url.URL{Path: t.socket + "/"}).EscapedPath()
+	path := UnixAPIPath + strings.TrimPrefix(req.URL.EscapedPath(), socket)
+	r := req.Clone(req.Context())
+	r.URL.Scheme = "http"
+	r.URL.Host = "localhost"
+	r.URL.Path, _ = url.PathUnescape(path)
+	r.URL.RawPath = path
+	r.Host = "localhost"
+
+	return t.transport.RoundTrip(r)
+}
+
+// CloseIdleConnections closes idle connections to the socket, it's called by http.Client.
+func (t *unixTransport) CloseIdleConnections() {
+	t.transport.CloseIdleConnections()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnixSocketTransport(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "lm.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("[TestUnixSocketTransport] Unable to listen to socket: %s", err.Error())
+	}
+	paths := make(chan string, 10)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.EscapedPath()
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	config := &Config{HTTPTimeout: 10 * time.Second}
+	err = findConfigOption("api_host").set(config, "unix://"+socket)
+	if err != nil {
+		t.Fatalf("[TestUnixSocketTransport] Unable to set api_host: %s", err.Error())
+	}
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		t.Fatalf("[TestUnixSocketTransport] Unable to create client: %s", err.Error())
+	}
+	api := NewAPI(config.APIHost, client)
+	err = api.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("[TestUnixSocketTransport] Ping failed: %s", err.Error())
+	}
+	if path := <-paths; path != "/api/ping" {
+		t.Errorf("[TestUnixSocketTransport] Expected=/api/ping;Actual=%s;", path)
+	}
+	err = api.TagDelete(context.Background(), "token", "key", "c/c++")
+	if err != nil {
+		t.Fatalf("[TestUnixSocketTransport] TagDelete failed: %s", err.Error())
+	}
+	if path := <-paths; path != "/api/tag/c%2Fc++" {
+		t.Errorf("[TestUnixSocketTransport] Expected=/api/tag/c%%2Fc++;Actual=%s;", path)
+	}
+
+	invalid := []string{"unix://lm.sock", "unix:///", "unix:///run/lm.sock?x=1"}
+	for _, value := range invalid {
+		if findConfigOption("api_host").set(&Config{}, value) == nil {
+			t.Errorf("[TestUnixSocketTransport] %s should be rejected", value)
+		}
+	}
+}
+
+func TestHTTPProxy(t *testing.T) {
+	hosts := make(chan string, 10)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- r.URL.Host
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	config := &Config{APIHost: "http://links.example/api/", Proxy: proxy.URL, HTTPTimeout: 10 * time.Second}
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		t.Fatalf("[TestHTTPProxy] Unable to create client: %s", err.Error())
+	}
+	err = NewAPI(config.APIHost, client).Ping(context.Background())
+	if err != nil {
+		t.Fatalf("[TestHTTPProxy] Ping failed: %s", err.Error())
+	}
+	if host := <-hosts; host != "links.example" {
+		t.Errorf("[TestHTTPProxy] Expected=links.example;Actual=%s;", host)
+	}
+
+	for _, value := range []string{"ftp://proxy:21", "localhost:3128", "socks5://"} {
+		if findConfigOption("proxy").set(&Config{}, value) == nil {
+			t.Errorf("[TestHTTPProxy] %s should be rejected", value)
+		}
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:3128")
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("NO_PROXY", "internal.example")
+	cases := []struct {
+		proxy    string
+		url      string
+		expected string
+	}{
+		{"", "https://links.example/api/", "http://env-proxy:3128"},
+		{"", "https://links.internal.example/api/", ""},
+		{"", "http://links.example/api/", ""},
+		{"socks5://localhost:1080", "https://links.example/api/", "socks5://localhost:1080"},
+		{"socks5://localhost:1080", "http://links.example/api/", "socks5://localhost:1080"},
+		{"socks5://localhost:1080", "https://links.internal.example/api/", ""},
+		{ProxyDirect, "https://links.example/api/", ""},
+	}
+	for _, c := range cases {
+		proxyFunc, err := newProxyFunc(c.proxy)
+		if err != nil {
+			t.Fatalf("[TestProxyFromEnvironment] Unable to create proxy function: %s", err.Error())
+		}
+		actual := ""
+		if proxyFunc != nil {
+			req, _ := http.NewRequest("GET", c.url, nil)
+			u, err := proxyFunc(req)
+			if err != nil {
+				t.Fatalf("[TestProxyFromEnvironment] Proxy function failed: %s", err.Error())
+			}
+			if u != nil {
+				actual = u.String()
+			}
+		}
+		if actual != c.expected {
+			t.Errorf("[TestProxyFromEnvironment] %q %s Expected=%q;Actual=%q;", c.proxy, c.url, c.expected, actual)
+		}
+	}
+}
+
+// serveSOCKS5 accepts connections without authentication and connects them to the target, ignoring
+// the requested address. Requested addresses are sent to the channel.
+func serveSOCKS5(listener net.Listener, target string, requested chan string) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			// Greeting: version, number of methods and methods
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+				return
+			}
+			conn.Write([]byte{5, 0})
+			// Request: version, command, reserved, address type and address
+			request := make([]byte, 4)
+			if _, err := io.ReadFull(conn, request); err != nil {
+				return
+			}
+			var host string
+			switch request[3] {
+			case 1:
+				ip := make([]byte, 4)
+				io.ReadFull(conn, ip)
+				host = net.IP(ip).String()
+			case 3:
+				length := make([]byte, 1)
+				io.ReadFull(conn, length)
+				name := make([]byte, length[0])
+				io.ReadFull(conn, name)
+				host = string(name)
+			default:
+				return
+			}
+			port := make([]byte, 2)
+			io.ReadFull(conn, port)
+			requested <- net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+				return
+			}
+			defer upstream.Close()
+			conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+			go io.Copy(upstream, conn)
+			io.Copy(conn, upstream)
+		}()
+	}
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("[TestSOCKS5Proxy] Unable to listen: %s", err.Error())
+	}
+	requested := make(chan string, 10)
+	target, _ := url.Parse(server.URL)
+	go serveSOCKS5(listener, target.Host, requested)
+	defer listener.Close()
+
+	config := &Config{APIHost: "http://links.example:8080/api/", Proxy: "socks5://" + listener.Addr().String(), HTTPTimeout: 10 * time.Second}
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		t.Fatalf("[TestSOCKS5Proxy] Unable to create client: %s", err.Error())
+	}
+	err = NewAPI(config.APIHost, client).Ping(context.Background())
+	if err != nil {
+		t.Fatalf("[TestSOCKS5Proxy] Ping failed: %s", err.Error())
+	}
+	if addr := <-requested; addr != "links.example:8080" {
+		t.Errorf("[TestSOCKS5Proxy] Expected=links.example:8080;Actual=%s;", addr)
+	}
+}
@@ -1,11 +1,52 @@
 package main
 
-// User user type.
+import (
+	"bytes"
+)
+
+// User user type as it's returned by the server, password is never sent back.
 type User struct {
+	ID       string `json:"id,omitempty"`
+	Username string `json:"username"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// String formats user as a single line: id, username and status.
+func (user *User) String() string {
+	var b bytes.Buffer
+	if user.ID != "" {
+		b.WriteString("[" + user.ID + "] ")
+	}
+	b.WriteString(user.Username)
+	if user.Disabled {
+		b.WriteString(" (disabled)")
+	}
+
+	return b.String()
+}
+
+// LoginRequest is the payload of authentication request.
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// UserCreateRequest is the payload of new user request.
+type UserCreateRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
+// PasswordChangeRequest is the payload of password change request.
+type PasswordChangeRequest struct {
+	Password string `json:"password"`
+}
+
+// UserUpdateRequest is the payload of user update request, only given fields are changed.
+type UserUpdateRequest struct {
+	Disabled *bool `json:"disabled,omitempty"`
+}
+
 // UserCredentials current user credentials.
 type UserCredentials struct {
 	Username string
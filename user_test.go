@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUserManagement(t *testing.T) {
+	bodies := map[string]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/user/login", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-AUTH-TOKEN", "token")
+	})
+	mux.HandleFunc("/api/user", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]*User{{ID: "1", Username: "admin"}, {ID: "2", Username: "guest", Disabled: true}})
+	})
+	mux.HandleFunc("/api/user/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-AUTH-TOKEN") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies[r.Method+" "+r.URL.Path] = strings.TrimSpace(string(b))
+		switch {
+		case r.URL.Path == "/api/user/3":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == "GET":
+			json.NewEncoder(w).Encode(&User{ID: "2", Username: "guest"})
+		case r.Method == "POST" && r.URL.Path == "/api/user/2":
+			json.NewEncoder(w).Encode(&User{ID: "2", Username: "guest", Disabled: true})
+		}
+	})
+	auth, _ := newTestAuthServer(t, mux)
+
+	users, err := listUsers(context.Background(), auth)
+	if err != nil || len(users) != 2 || users[1].String() != "[2] guest (disabled)" {
+		t.Errorf("[TestUserManagement] Unexpected users %v %v", users, err)
+	}
+	user, err := getUser(context.Background(), auth, "2")
+	if err != nil || user.Username != "guest" {
+		t.Errorf("[TestUserManagement] Unexpected user %v %v", user, err)
+	}
+	_, err = getUser(context.Background(), auth, "3")
+	if e, ok := err.(*APIError); !ok || e.StatusCode() != http.StatusNotFound {
+		t.Errorf("[TestUserManagement] Not found error expected, given %v", err)
+	}
+	user, err = setUserDisabled(context.Background(), auth, "2", true)
+	if err != nil || !user.Disabled {
+		t.Errorf("[TestUserManagement] Unexpected disabled user %v %v", user, err)
+	}
+	if body := bodies["POST /api/user/2"]; body != `{"disabled":true}` {
+		t.Errorf("[TestUserManagement] Only status should be sent, given %s", body)
+	}
+	prompt := NewPrompt(strings.NewReader("secret\nsecret\n"), ioutil.Discard)
+	err = changeUserPassword(context.Background(), auth, "2", prompt)
+	if err != nil {
+		t.Errorf("[TestUserManagement] Changing password failed: %s", err.Error())
+	}
+	if body := bodies["POST /api/user/2/password"]; body != `{"password":"secret"}` {
+		t.Errorf("[TestUserManagement] Unexpected password change payload %s", body)
+	}
+	err = deleteUser(context.Background(), auth, "2")
+	if _, ok := bodies["DELETE /api/user/2"]; err != nil || !ok {
+		t.Errorf("[TestUserManagement] User should be removed, given %v", err)
+	}
+}